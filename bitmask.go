@@ -0,0 +1,54 @@
+package struct2csv
+
+import (
+	"math/bits"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// bitmask is the WithBitmaskLabels labelling of a field
+type bitmask struct {
+	labels    map[uint64]string
+	separator string
+}
+
+// bitmaskLabels formats an integer value as the labels of its set bits from
+// the lowest, ok is false when value is not an integer
+func bitmaskLabels(
+	value reflect.Value,
+	mask bitmask,
+	cfg *config,
+) (string, bool) {
+	value, ok := indirect(value)
+	if !ok {
+		return nullString, true
+	}
+	var n uint64
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = uint64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = value.Uint()
+	default:
+		return "", false
+	}
+	if n == 0 {
+		return mask.labels[0], true
+	}
+
+	var labels []string
+	for n != 0 {
+		bit := bits.TrailingZeros64(n)
+		n &^= 1 << bit
+		label, ok := mask.labels[1<<bit]
+		if !ok {
+			if cfg.skipUnlabeledBits {
+				continue
+			}
+			label = "bit" + strconv.Itoa(bit)
+		}
+		labels = append(labels, label)
+	}
+	return strings.Join(labels, mask.separator), true
+}
@@ -0,0 +1,134 @@
+package struct2csv
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// writeBuffered is writeHeader followed by writeRows formatting every row
+// before writing any, for the options that need to see all rows
+func writeBuffered(
+	writer *recordWriter,
+	value reflect.Value,
+	elemType reflect.Type,
+	cfg *config,
+) (int, error) {
+	headers, err := headerRecord(elemType, cfg)
+	if err != nil {
+		return 0, fmt.Errorf("failed to extract headers: %w", err)
+	}
+
+	rows := make([]record, 0, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		if err := cfg.ctxErr(); err != nil {
+			return 0, err
+		}
+		if !cfg.keepRow(value.Index(i)) {
+			continue
+		}
+		elem, err := element(value, i, elemType, cfg)
+		if err != nil {
+			return 0, err
+		}
+		records, err := rowRecords(elem, elemType, cfg)
+		if err != nil {
+			err = fmt.Errorf("failed to extract row %d: %w", i, err)
+			row, ok := cfg.collectRowError(err, len(headers))
+			if !ok {
+				return 0, err
+			}
+			records = []record{row}
+		}
+		for _, row := range records {
+			row.index = cfg.inputIndex(i)
+			rows = append(rows, row)
+		}
+	}
+	if len(cfg.sortBy) > 0 {
+		if err := sortRows(rows, headers, cfg); err != nil {
+			return 0, err
+		}
+	}
+
+	columns := make([]bool, len(headers))
+	for i := range columns {
+		columns[i] = true
+	}
+	if cfg.omitUnchanged() {
+		omitUnchanged(columns, rows)
+	}
+	if cfg.trimEmptyColumns {
+		trimEmptyColumns(columns, rows)
+	}
+
+	var groups, types, units []string
+	if cfg.groupedHeaders {
+		groups = keepColumns(groupRecord(elemType, cfg), columns)
+	}
+	if cfg.typeHeaderRow {
+		types = keepColumns(typeRecord(elemType, cfg), columns)
+	}
+	if cfg.unitsRow {
+		units = keepColumns(unitRecord(elemType, cfg), columns)
+	}
+	headers = keepColumns(headers, columns)
+	if cfg.transpose {
+		return writeTransposed(writer, headers, rows, columns, cfg)
+	}
+	err = writeHeaders(writer, groups, headers, types, units, cfg)
+	if err != nil {
+		return 0, err
+	}
+	for i, row := range rows {
+		if err := writeRow(writer, row.keep(columns), cfg); err != nil {
+			return i, fmt.Errorf("failed to write row %d: %w", i, err)
+		}
+		if err := periodicFlush(writer, i+1, cfg); err != nil {
+			return i + 1, err
+		}
+	}
+	return len(rows), nil
+}
+
+// keepColumns returns the fields at the columns marked in columns
+func keepColumns(fields []string, columns []bool) []string {
+	var kept []string
+	for i, ok := range columns {
+		if ok {
+			kept = append(kept, fields[i])
+		}
+	}
+	return kept
+}
+
+// omitUnchanged unmarks the columns equal to the baseline in every row
+func omitUnchanged(columns []bool, rows []record) {
+	if len(rows) == 0 {
+		return
+	}
+	for i := range columns {
+		changed := false
+		for _, row := range rows {
+			if !row.unchanged[i] {
+				changed = true
+				break
+			}
+		}
+		columns[i] = columns[i] && changed
+	}
+}
+
+// trimEmptyColumns unmarks the trailing columns empty in every row
+func trimEmptyColumns(columns []bool, rows []record) {
+	if len(rows) == 0 {
+		return
+	}
+	for i := len(columns) - 1; i >= 0; i-- {
+		for _, row := range rows {
+			if row.fields[i] != "" {
+				return
+			}
+		}
+		columns[i] = false
+	}
+}
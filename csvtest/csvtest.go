@@ -0,0 +1,55 @@
+// Package csvtest provides helpers for testing code that writes csv with
+// struct2csv
+package csvtest
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/m-row/struct2csv"
+)
+
+// AssertConsistent writes data with opts and fails t when the header and
+// every row don't have the same number of fields, data may be an empty
+// slice, the output must be readable by encoding/csv so options writing a
+// leading comment or another quote character are not supported
+//
+//	func TestExport(t *testing.T) {
+//		csvtest.AssertConsistent(t, orders, struct2csv.WithRowNumbers("#", false))
+//	}
+func AssertConsistent(t testing.TB, data any, opts ...struct2csv.Option) {
+	t.Helper()
+
+	var b bytes.Buffer
+	if err := struct2csv.Write(&b, data, opts...); err != nil {
+		t.Fatalf("failed to write csv: %v", err)
+	}
+
+	r := csv.NewReader(strings.NewReader(strings.TrimPrefix(b.String(), "\ufeff")))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		t.Fatalf("failed to read header: %v", err)
+	}
+	for n := 1; ; n++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			t.Fatalf("failed to read record %d: %v", n, err)
+		}
+		if len(record) != len(header) {
+			t.Errorf(
+				"record %d has %d fields, header has %d",
+				n,
+				len(record),
+				len(header),
+			)
+		}
+	}
+}
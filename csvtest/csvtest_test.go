@@ -0,0 +1,78 @@
+package csvtest
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/m-row/struct2csv"
+)
+
+type order struct {
+	ID    int     `csv:"id"`
+	Total float64 `csv:"total"`
+}
+
+// recorder is a testing.TB recording failures instead of failing the test
+type recorder struct {
+	testing.TB
+	errors []string
+	fatal  bool
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...any) {
+	r.fatal = true
+	r.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// run calls AssertConsistent with a recorder in its own goroutine so that
+// Fatalf can stop it
+func run(data any, opts ...struct2csv.Option) *recorder {
+	r := &recorder{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		AssertConsistent(r, data, opts...)
+	}()
+	<-done
+	return r
+}
+
+func TestAssertConsistent(t *testing.T) {
+	orders := []order{{1, 10}, {2, 20}}
+	if r := run(orders, struct2csv.WithRowNumbers("#", false)); len(r.errors) > 0 {
+		t.Errorf("consistent: got errors %q", r.errors)
+	}
+	if r := run([]order{}); len(r.errors) > 0 {
+		t.Errorf("empty slice: got errors %q", r.errors)
+	}
+
+	if r := run([]int{1}); !r.fatal {
+		t.Errorf("invalid data: got errors %q, want a fatal failure", r.errors)
+	}
+}
+
+func TestAssertConsistentMisaligned(t *testing.T) {
+	orders := []order{{1, 10}, {2, 20}}
+	// a buggy hook dropping the last cell of the data rows
+	misaligned := struct2csv.WithRecordHook(func(record []string) ([]string, error) {
+		if record[0] == "id" {
+			return record, nil
+		}
+		return record[:len(record)-1], nil
+	})
+	r := run(orders, misaligned)
+	if r.fatal || len(r.errors) != 2 {
+		t.Fatalf("misaligned: got errors %q", r.errors)
+	}
+	if want := "record 1 has 1 fields, header has 2"; r.errors[0] != want {
+		t.Errorf("got %q, want %q", r.errors[0], want)
+	}
+}
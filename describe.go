@@ -0,0 +1,74 @@
+package struct2csv
+
+import (
+	"errors"
+	"reflect"
+)
+
+// DescribeRow returns the header to cell mapping v, a struct or pointer to
+// struct, would be written with, it's meant for debugging blank columns,
+// under WithExplode that of its first row
+//
+//	cells, err := struct2csv.DescribeRow(user)
+//	fmt.Println(cells["status"])
+func DescribeRow(v any, opts ...Option) (map[string]string, error) {
+	cfg := newConfig(opts)
+	value := reflect.ValueOf(v)
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil, errors.New("value is nil")
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil, errors.New("value is not a struct")
+	}
+	elemType := value.Type()
+	if err := cfg.bind(elemType); err != nil {
+		return nil, err
+	}
+
+	headers, err := headerRecord(elemType, cfg)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := rowRecords(value, elemType, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cells := make(map[string]string, len(headers))
+	for i, header := range headers {
+		cells[header] = rows[0].fields[i]
+	}
+	return cells, nil
+}
+
+// BuildHeaders returns the header record Write writes for elemType, a
+// struct or pointer to struct, with opts, for tools writing the rows some
+// other way, columns only left out depending on the rows, like under
+// WithTrimEmptyColumns, are included
+//
+//	headers, err := struct2csv.BuildHeaders(reflect.TypeOf(User{}))
+func BuildHeaders(elemType reflect.Type, opts ...Option) ([]string, error) {
+	cfg := newConfig(opts)
+	if elemType == nil {
+		return nil, errors.New("type is nil")
+	}
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return nil, errors.New("type is not a struct")
+	}
+	if err := cfg.bind(elemType); err != nil {
+		return nil, err
+	}
+	headers, err := headerRecord(elemType, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.rowNumbers {
+		headers = append([]string{cfg.rowNumberHeader}, headers...)
+	}
+	return headers, nil
+}
@@ -0,0 +1,77 @@
+// Package struct2csv writes slices of structs as csv, a column per field
+// named by its csv struct tag
+//
+// # Fields
+//
+// to ignore a field give it the csv tag "-", to name a column "-" use "-,",
+// unexported fields and func fields are always ignored
+//
+// embedded structs without a csv name are flattened into the parent columns,
+// even when their type is unexported, and nested structs are written as
+// columns prefixed by the field header, at any depth
+//
+// pointer sub-structs are expanded like sub-structs, blank when nil, see
+// WithNilStructMode, a pointer back to a struct type already expanded, like a
+// Parent *T field of T, is a single blank column
+//
+// maps are written in one cell as key=value pairs sorted by key, see
+// WithMapSeparator, and slices and arrays as their elements joined, see
+// WithSliceSeparator, defined types like type Tags []string included, pointer
+// elements are dereferenced and nil ones written as nullString
+//
+// []byte is written base64 encoded like encoding/json
+//
+// a value with a MarshalCSV method is written with it, then one with a
+// MarshalText or String method like a string enum or a decimal struct,
+// numeric types like an int enum or time.Duration only under
+// WithNumberStringers, an embedded interface is one column written with the
+// String method of its value
+//
+// fields of kinds without a csv representation like channels and funcs are
+// written as nullString or fail under WithStrict, to write the values
+// received from a channel use WriteChan
+//
+// the csv_profiles tag lists the WithProfile profiles a field is written
+// under, `csv_profiles:"admin,export"`
+//
+// # Tag options
+//
+// options follow the header name in the csv tag separated by commas
+//
+// bytes writes a []byte as text as is with bytes=string, or with bytes=hex
+// or bytes=base64, `csv:"payload,bytes=string"`
+//
+// split writes a time field as a column per part, `csv:"ts,split=date|time"`
+// writes ts.date as 2006-01-02 and ts.time as 15:04, any other part is a
+// time.Format layout
+//
+// noprefix writes the columns of a nested struct with just their own headers
+// like an embedded struct, `csv:"user,noprefix"`, Validate reports headers
+// that then collide
+//
+// inline=json writes a nested struct as a single compact JSON cell instead of
+// its own columns, `csv:"المستخدم,inline=json"`
+//
+// as=char writes a rune or byte as its character instead of its number,
+// `csv:"initial,as=char"`
+//
+// pad pads a cell with spaces to a fixed width, aligned left or with
+// align=right to the right, `csv:"code,pad=8,align=right"`, see
+// WithPadOverflow for values longer than the width
+//
+// invert writes the negation of a bool, such as an "enabled" column for a
+// Disabled field, `csv:"enabled,invert"`
+//
+// unit is the unit of a column written in the WithUnitsRow row,
+// `csv:"mass,unit=kg"`
+//
+// exceltext keeps Excel from reading a numeric looking string like the zip
+// code "01234" as a number, it's written as the formula ="01234" which other
+// tools read as is, `csv:"zip,exceltext"`
+//
+// scale writes an integer amount in minor units like cents as a decimal,
+// 1999 is written as 19.99 by `csv:"amount,scale=2"`
+//
+// string has parsers read a number or bool column like an ID as text, like
+// encoding/json, its cells are always quoted, `csv:"id,string"`
+package struct2csv
@@ -0,0 +1,17 @@
+package struct2csv
+
+import "io"
+
+// EachRow passes every record of data, a slice of structs or pointers to
+// structs, to fn instead of writing it, the header records first unless
+// WithSkipHeader is set, an error returned by fn aborts the export, for sinks
+// like a message queue taking one record at a time
+//
+//	err := struct2csv.EachRow(users, func(record []string) error {
+//		return queue.Publish(ctx, record)
+//	})
+func EachRow(data any, fn func(record []string) error, opts ...Option) error {
+	cfg := newConfig(opts)
+	cfg.sink = fn
+	return write(io.Discard, data, cfg)
+}
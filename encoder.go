@@ -0,0 +1,134 @@
+package struct2csv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Encoder writes slices of structs as csv to an io.Writer across several
+// calls, the header is written once for the first slice encoded
+//
+//	enc := struct2csv.NewEncoder(w)
+//	for page := range pages {
+//		if err := enc.Encode(page); err != nil {
+//			return err
+//		}
+//	}
+//	return enc.Close()
+type Encoder struct {
+	w        io.Writer
+	cfg      *config
+	writer   *recordWriter
+	elemType reflect.Type
+}
+
+// ErrBufferedOption is returned by Encoder, WriteSQLRows and WriteNDJSON,
+// which write rows as they come, for the options that need all rows first:
+// WithSortBy, WithTrimEmptyColumns, WithTranspose and DiffOmit
+var ErrBufferedOption = errors.New("option needs all rows before writing")
+
+// NewEncoder returns an Encoder writing to w with opts, the options that
+// need all rows are not supported, see ErrBufferedOption
+func NewEncoder(w io.Writer, opts ...Option) *Encoder {
+	return &Encoder{w: w, cfg: newConfig(opts)}
+}
+
+// Encode writes the rows of data, the header is written before the first
+// rows and every call must use the same element type as the current section
+func (e *Encoder) Encode(data any) error {
+	if err := e.init(); err != nil {
+		return err
+	}
+	value, elemType, err := sliceType(data, e.cfg)
+	if err != nil {
+		return err
+	}
+	if e.elemType == nil {
+		if err := writePreamble(e.writer, elemType, e.cfg); err != nil {
+			return err
+		}
+		if err := writeHeader(e.writer, elemType, e.cfg); err != nil {
+			return err
+		}
+		e.elemType = elemType
+	} else if e.elemType != elemType {
+		return fmt.Errorf(
+			"cannot encode %s into a section of %s, use WriteSection",
+			elemType,
+			e.elemType,
+		)
+	}
+	_, err = writeRows(e.writer, value, elemType, e.cfg)
+	return e.cfg.joinRowErrors(err)
+}
+
+// WriteSection starts a new section with its own header for data, which may
+// be of a different type than the previous section, sections after the first
+// are preceded by a blank line, later Encode calls append to this section,
+// WithRowNumbers numbers the rows of every section from 1
+func (e *Encoder) WriteSection(data any) error {
+	if err := e.init(); err != nil {
+		return err
+	}
+	value, elemType, err := sliceType(data, e.cfg)
+	if err != nil {
+		return err
+	}
+	if e.elemType != nil {
+		if err := e.writer.Write(nil); err != nil {
+			return fmt.Errorf("failed to write section separator: %w", err)
+		}
+	} else if err := writePreamble(e.writer, elemType, e.cfg); err != nil {
+		return err
+	}
+	if err := writeHeader(e.writer, elemType, e.cfg); err != nil {
+		return err
+	}
+	e.elemType = elemType
+	e.writer.section = e.writer.rows
+	_, err = writeRows(e.writer, value, elemType, e.cfg)
+	return e.cfg.joinRowErrors(err)
+}
+
+// Flush writes any buffered data to the underlying io.Writer, flushing the
+// gzip stream of WithGzip too
+func (e *Encoder) Flush() error {
+	if e.writer == nil {
+		return nil
+	}
+	e.writer.Flush()
+	if err := e.writer.Error(); err != nil {
+		return err
+	}
+	if e.writer.gzip != nil {
+		return e.writer.gzip.Flush()
+	}
+	return nil
+}
+
+// Close flushes the Encoder and closes the gzip stream of WithGzip, the
+// underlying io.Writer is not closed
+func (e *Encoder) Close() error {
+	if e.writer == nil {
+		return nil
+	}
+	return e.writer.finish(e.cfg)
+}
+
+// init creates the record writer on first use
+func (e *Encoder) init() error {
+	if e.writer != nil {
+		return nil
+	}
+	if e.cfg.buffered() {
+		return ErrBufferedOption
+	}
+	writer, err := newRecordWriter(e.w, e.cfg)
+	if err != nil {
+		return err
+	}
+	e.writer = writer
+	return nil
+}
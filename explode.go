@@ -0,0 +1,134 @@
+package struct2csv
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// bindExplode resolves the WithExplode field of elemType to its index and
+// the struct type of its elements
+func (c *config) bindExplode(elemType reflect.Type) error {
+	if c.explodeField == "" {
+		return nil
+	}
+	field, ok := elemType.FieldByName(c.explodeField)
+	if !ok || len(field.Index) != 1 {
+		return fmt.Errorf(
+			"explode field %q not found in %s",
+			c.explodeField,
+			elemType,
+		)
+	}
+	if !field.IsExported() {
+		return fmt.Errorf("explode field %q is not exported", field.Name)
+	}
+	t := field.Type
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		return fmt.Errorf("explode field %q is not a slice", field.Name)
+	}
+	t = t.Elem()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if !isSubStructType(t) {
+		return fmt.Errorf("explode field %q is not a slice of structs", field.Name)
+	}
+	c.explodeIndex = field.Index[0]
+	c.explodeType = t
+	return nil
+}
+
+// isExploded reports whether field i of elemType is the WithExplode field
+func (c *config) isExploded(elemType reflect.Type, i int) bool {
+	return c.explodeIndex == i && c.elemType == elemType
+}
+
+// explodeHeaders returns the headers of the WithExplode columns, the
+// WithExplodeIndex column followed by the element struct headers
+func explodeHeaders(cfg *config) ([]string, error) {
+	field := cfg.elemType.Field(cfg.explodeIndex)
+	subHeaders, err := extractHeaders(cfg.explodeType, field.Name, cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	var headers []string
+	if cfg.childIndex {
+		headers = append(headers, cfg.childIndexHeader)
+	}
+	name := cfg.translateHeader(headerName(field))
+	for _, subHeader := range subHeaders {
+		headers = append(headers, name+cfg.headerSeparator+subHeader)
+	}
+	return headers, nil
+}
+
+// explodeColumns returns a cell per WithExplode column, index for the
+// WithExplodeIndex column and cell for those of the element struct like
+// extractColumns
+func explodeColumns(
+	cfg *config,
+	index string,
+	cell func(field reflect.StructField, path string, cfg *config) string,
+) []string {
+	var cells []string
+	if cfg.childIndex {
+		cells = append(cells, index)
+	}
+	path := cfg.elemType.Field(cfg.explodeIndex).Name
+	return append(cells, extractColumns(cfg.explodeType, path, cfg, nil, cell)...)
+}
+
+// explodeRows returns a row per element of the WithExplode field of the
+// struct value, parent followed by the cells of the element
+func explodeRows(parent record, value reflect.Value, cfg *config) ([]record, error) {
+	path := cfg.elemType.Field(cfg.explodeIndex).Name
+	children, ok := indirect(value.Field(cfg.explodeIndex))
+	if !ok || children.Len() == 0 {
+		var row record
+		row.extend(parent)
+		if cfg.childIndex {
+			row.add(nullString, false)
+		}
+		blank, err := extractRow(
+			reflect.Value{},
+			reflect.Value{},
+			cfg.explodeType,
+			path,
+			cfg,
+			nil,
+		)
+		if err != nil {
+			return nil, err
+		}
+		row.extend(blank)
+		return []record{row}, nil
+	}
+
+	rows := make([]record, 0, children.Len())
+	for k := 0; k < children.Len(); k++ {
+		child := children.Index(k)
+		if child.Kind() == reflect.Ptr {
+			child = child.Elem()
+		}
+		sub, err := extractRow(
+			child,
+			reflect.Value{},
+			cfg.explodeType,
+			path,
+			cfg,
+			nil,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s index %d: %w", path, k, err)
+		}
+		var row record
+		row.extend(parent)
+		if cfg.childIndex {
+			row.add(strconv.Itoa(k+1), false)
+		}
+		row.extend(sub)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
@@ -1,3 +1,3 @@
 module github.com/m-row/struct2csv
 
-go 1.22.3
+go 1.23
@@ -0,0 +1,92 @@
+package struct2csv
+
+import (
+	"reflect"
+	"strings"
+)
+
+// groupRecord generates the WithGroupedHeaders group of every column of
+// headerRecord, the header of the nested struct field the column belongs to
+// or blank for a column of elemType itself
+func groupRecord(elemType reflect.Type, cfg *config) []string {
+	groups := extractGroups(elemType, "", cfg, nil)
+	if cfg.explodeIndex >= 0 {
+		field := elemType.Field(cfg.explodeIndex)
+		name := cfg.translateHeader(headerName(field))
+		group := func(reflect.StructField, string, *config) string {
+			return name
+		}
+		groups = append(groups, explodeColumns(cfg, name, group)...)
+	}
+	if cfg.catchAllIndex >= 0 {
+		groups = append(groups, "")
+	}
+	return groups
+}
+
+// extractGroups walks the fields of elemType the way extractHeaders does,
+// the fields of embedded structs are columns of elemType itself and those
+// of noprefix ones have no group
+func extractGroups(
+	elemType reflect.Type,
+	prefix string,
+	cfg *config,
+	parents []reflect.Type,
+) []string {
+	parents = append(parents, elemType)
+
+	var groups []string
+	for i := 0; i < elemType.NumField(); i++ {
+		field := elemType.Field(i)
+		if cfg.skipField(elemType, i) {
+			continue
+		}
+
+		path := fieldPath(prefix, field.Name)
+		if parts := splitParts(field); parts != nil {
+			name := cfg.translateHeader(headerName(field))
+			for range parts {
+				groups = append(groups, name)
+			}
+			continue
+		}
+		if !cfg.expandNested(field, path, parents) {
+			groups = append(groups, "")
+			continue
+		}
+		if isEmbeddedStruct(field) {
+			subGroups := extractGroups(subStructType(field), prefix, cfg, parents)
+			groups = append(groups, subGroups...)
+			continue
+		}
+		name := cfg.translateHeader(headerName(field))
+		if fieldOptions(field).Contains("noprefix") {
+			name = ""
+		}
+		subCells := extractColumns(subStructType(field), path, cfg, parents, unit)
+		for range subCells {
+			groups = append(groups, name)
+		}
+	}
+	return groups
+}
+
+// groupRows returns the group row with each group named once at the start
+// of its span and the headers without their group prefix
+func groupRows(groups, headers []string, cfg *config) ([]string, []string) {
+	groupRow := make([]string, len(groups))
+	leaves := make([]string, len(headers))
+	for i, header := range headers {
+		leaves[i] = header
+		if i >= len(groups) || groups[i] == "" {
+			continue
+		}
+		if i == 0 || groups[i-1] != groups[i] {
+			groupRow[i] = groups[i]
+		}
+		if leaf, ok := strings.CutPrefix(header, groups[i]+cfg.headerSeparator); ok {
+			leaves[i] = leaf
+		}
+	}
+	return groupRow, leaves
+}
@@ -0,0 +1,75 @@
+package struct2csv
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// WriteCSVRequest is WriteCSV for a request, it replies 304 Not Modified
+// without writing data when the If-None-Match or If-Modified-Since headers
+// of r match the values given by WithETag or WithLastModified
+func WriteCSVRequest(
+	w http.ResponseWriter,
+	r *http.Request,
+	filename string,
+	data any,
+	opts ...Option,
+) error {
+	cfg := newConfig(opts)
+	h := w.Header()
+	setHeaders(h, cfg.filename(filename, data), cfg)
+	if isNotModified(r, cfg) {
+		h.Del("Content-Type")
+		h.Del("Content-Disposition")
+		h.Del("Content-Encoding")
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	return write(w, data, cfg)
+}
+
+// isNotModified evaluates the conditional headers of a GET or HEAD request,
+// If-None-Match takes precedence over If-Modified-Since
+func isNotModified(r *http.Request, cfg *config) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return cfg.etag != "" && etagMatch(inm, cfg.etag)
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || cfg.lastModified.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !cfg.lastModified.Truncate(time.Second).After(t)
+}
+
+// etagMatch reports whether the If-None-Match list contains etag using the
+// weak comparison
+func etagMatch(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeFilename drops the characters that could break out of the quoted
+// Content-Disposition filename or name a path
+func sanitizeFilename(filename string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '"' || r == '\\' || r == '/' || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, filename)
+}
@@ -0,0 +1,110 @@
+package struct2csv
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Marshaler is implemented by types that format themselves into a csv cell,
+// a struct implementing it is written as a single column
+type Marshaler interface {
+	MarshalCSV() (string, error)
+}
+
+// ErrUnsupportedType is returned under WithStrict for fields whose kind has
+// no csv representation
+var ErrUnsupportedType = errors.New("unsupported field type")
+
+var (
+	marshalerType     = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// implementsMarshaler reports whether values of t or *t implement Marshaler
+func implementsMarshaler(t reflect.Type) bool {
+	return t.Implements(marshalerType) ||
+		reflect.PointerTo(t).Implements(marshalerType)
+}
+
+// marshalCell calls MarshalCSV when value or its address implements
+// Marshaler, ok is false when it does not
+func marshalCell(value reflect.Value) (cell string, ok bool, err error) {
+	receiver, ok := implementer(value, marshalerType)
+	if !ok {
+		return "", false, nil
+	}
+	cell, err = receiver.Interface().(Marshaler).MarshalCSV()
+	return cell, true, err
+}
+
+// implementer returns value, or its address when only *T implements iface,
+// a value that is not addressable, like a map value or the value held by an
+// interface, is copied to be addressed, ok is false when neither implements
+// iface
+func implementer(value reflect.Value, iface reflect.Type) (reflect.Value, bool) {
+	if !value.CanInterface() {
+		return reflect.Value{}, false
+	}
+	if value.Type().Implements(iface) {
+		return value, true
+	}
+	if !reflect.PointerTo(value.Type()).Implements(iface) {
+		return reflect.Value{}, false
+	}
+	if value.CanAddr() {
+		return value.Addr(), true
+	}
+	ptr := reflect.New(value.Type())
+	ptr.Elem().Set(value)
+	return ptr, true
+}
+
+// implementsText reports whether values of t or *t implement
+// encoding.TextMarshaler or fmt.Stringer, like an enum type, time types are
+// written with the time layout instead
+func implementsText(t reflect.Type) bool {
+	if isTimeType(t) {
+		return false
+	}
+	pt := reflect.PointerTo(t)
+	return t.Implements(textMarshalerType) || pt.Implements(textMarshalerType) ||
+		t.Implements(stringerType) || pt.Implements(stringerType)
+}
+
+// isTextStruct reports whether t is a struct implementing text, such as a
+// decimal type, written as one column instead of its fields
+func isTextStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && implementsText(t)
+}
+
+// isNumberKind reports whether k is an integer or float kind
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// formatText formats a value with MarshalText or else String, ok is false
+// when it has neither, is a time or is a number without WithNumberStringers
+func formatText(value reflect.Value, cfg *config) (cell string, ok bool, err error) {
+	if isTimeType(value.Type()) ||
+		(isNumberKind(value.Kind()) && !cfg.numberStringers) {
+		return "", false, nil
+	}
+	if receiver, ok := implementer(value, textMarshalerType); ok {
+		text, err := receiver.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), true, err
+	}
+	if receiver, ok := implementer(value, stringerType); ok {
+		return receiver.Interface().(fmt.Stringer).String(), true, nil
+	}
+	return "", false, nil
+}
@@ -0,0 +1,36 @@
+package struct2csv
+
+import (
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+)
+
+// WriteToPart writes data as a csv form file part named fieldname of mw,
+// the part has a text/csv content type unlike multipart.CreateFormFile
+//
+//	mw := multipart.NewWriter(body)
+//	err := struct2csv.WriteToPart(mw, "file", "users", users)
+func WriteToPart(
+	mw *multipart.Writer,
+	fieldname, filename string,
+	data any,
+	opts ...Option,
+) error {
+	cfg := newConfig(opts)
+	h := make(textproto.MIMEHeader)
+	h.Set(
+		"Content-Disposition",
+		fmt.Sprintf(
+			`form-data; name="%s"; filename="%s"`,
+			sanitizeFilename(fieldname),
+			sanitizeFilename(cfg.filename(filename, data)),
+		),
+	)
+	h.Set("Content-Type", "text/csv")
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return fmt.Errorf("failed to create part: %w", err)
+	}
+	return write(part, data, cfg)
+}
@@ -0,0 +1,103 @@
+package struct2csv
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// WriteNDJSON writes data as newline-delimited JSON to w, one object per
+// row keyed by the headers the csv would have, in the same order, with the
+// formatted cells as string values, the options that need all rows are not
+// supported, see ErrBufferedOption
+//
+//	{"الاسم":"أحمد","العنوان.المدينة":"طرابلس"}
+func WriteNDJSON(w io.Writer, data any, opts ...Option) error {
+	cfg := newConfig(opts)
+	return observe(w, cfg, func(w io.Writer) (int, error) {
+		return writeNDJSON(w, data, cfg)
+	})
+}
+
+// writeNDJSON writes the rows of data as JSON objects to w returning the
+// number of rows written
+func writeNDJSON(w io.Writer, data any, cfg *config) (rows int, err error) {
+	if cfg.buffered() {
+		return 0, ErrBufferedOption
+	}
+	if value := reflect.ValueOf(data); value.Kind() == reflect.Slice &&
+		value.Len() == 0 && cfg.emptyInput == EmptyInputError {
+		return 0, ErrEmptyInput
+	}
+	value, elemType, err := sliceType(data, cfg)
+	if err != nil {
+		return 0, err
+	}
+	headers, err := headerRecord(elemType, cfg)
+	if err != nil {
+		return 0, fmt.Errorf("failed to extract headers: %w", err)
+	}
+	if cfg.rowNumbers {
+		headers = append([]string{cfg.rowNumberHeader}, headers...)
+	}
+	keys := make([][]byte, len(headers))
+	for i, header := range headers {
+		if keys[i], err = json.Marshal(header); err != nil {
+			return 0, fmt.Errorf("failed to marshal header %q: %w", header, err)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	defer func() {
+		if flushErr := bw.Flush(); err == nil && flushErr != nil {
+			err = fmt.Errorf("failed to flush: %w", flushErr)
+		}
+	}()
+	for i := 0; i < value.Len(); i++ {
+		if err := cfg.ctxErr(); err != nil {
+			return rows, err
+		}
+		if !cfg.keepRow(value.Index(i)) {
+			continue
+		}
+		elem, err := element(value, i, elemType, cfg)
+		if err != nil {
+			return rows, err
+		}
+		records, err := rowRecords(elem, elemType, cfg)
+		if err != nil {
+			return rows, fmt.Errorf("failed to extract row %d: %w", i, err)
+		}
+		for _, row := range records {
+			row.index = cfg.inputIndex(i)
+			row = prepareRow(row, rows, headers, cfg)
+			if err := writeObject(bw, keys, row.fields); err != nil {
+				return rows, fmt.Errorf("failed to write row %d: %w", i, err)
+			}
+			rows++
+		}
+	}
+	return rows, nil
+}
+
+// writeObject writes a JSON object of keys to fields followed by a newline
+func writeObject(w *bufio.Writer, keys [][]byte, fields []string) error {
+	w.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.Write(keys[i])
+		w.WriteByte(':')
+		b, err := json.Marshal(field)
+		if err != nil {
+			return err
+		}
+		w.Write(b)
+	}
+	w.WriteByte('}')
+	_, err := w.WriteString("\n")
+	return err
+}
@@ -0,0 +1,122 @@
+package struct2csv
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Locale describes how digits and separators of formatted numbers are written
+type Locale struct {
+	Digits   [10]rune
+	Decimal  rune
+	Grouping rune
+}
+
+var (
+	// LocaleLatin writes numbers with ascii digits, '.' and ','
+	LocaleLatin = Locale{
+		Digits:   [10]rune{'0', '1', '2', '3', '4', '5', '6', '7', '8', '9'},
+		Decimal:  '.',
+		Grouping: ',',
+	}
+
+	// LocaleArabic writes numbers with arabic-indic digits and separators
+	LocaleArabic = Locale{
+		Digits:   [10]rune{'٠', '١', '٢', '٣', '٤', '٥', '٦', '٧', '٨', '٩'},
+		Decimal:  '٫',
+		Grouping: '٬',
+	}
+)
+
+// formatNumber applies grouping and locale digit shaping to a number
+// formatted by strconv, it is returned unchanged when neither is configured
+func (c *config) formatNumber(s string) string {
+	if !c.numberGrouping && c.locale == nil {
+		return s
+	}
+	locale := LocaleLatin
+	if c.locale != nil {
+		locale = *c.locale
+	}
+
+	// NaN and ±Inf have no digits to shape, nor does FloatAuto's exponent
+	// form
+	if strings.ContainsAny(s, "NIe") {
+		return s
+	}
+
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	intPart, fracPart, hasFrac := strings.Cut(s, ".")
+
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, d := range intPart {
+		if c.numberGrouping && i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteRune(locale.Grouping)
+		}
+		b.WriteRune(locale.Digits[d-'0'])
+	}
+	if hasFrac {
+		b.WriteRune(locale.Decimal)
+		for _, d := range fracPart {
+			b.WriteRune(locale.Digits[d-'0'])
+		}
+	}
+	return b.String()
+}
+
+// formatScaled formats an integer value in minor units such as cents as a
+// decimal with scale digits after the point, 1999 with scale 2 is 19.99,
+// the division is exact so nothing is rounded, ok is false when value is
+// not an integer
+func formatScaled(value reflect.Value, scale int, cfg *config) (string, bool) {
+	value, ok := indirect(value)
+	if !ok {
+		return nullString, true
+	}
+	var sign string
+	var abs uint64
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := value.Int()
+		abs = uint64(n)
+		if n < 0 {
+			sign, abs = "-", -abs
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Uintptr:
+		abs = value.Uint()
+	default:
+		return "", false
+	}
+	if cfg.blankZeroNumbers && abs == 0 {
+		return nullString, true
+	}
+	digits := strconv.FormatUint(abs, 10)
+	if scale == 0 {
+		return cfg.formatNumber(sign + digits), true
+	}
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+	point := len(digits) - scale
+	return cfg.formatNumber(sign + digits[:point] + "." + digits[point:]), true
+}
+
+// formatFloat formats a float of bitSize bits in the WithFloatFormat format,
+// FloatAuto uses strconv's shortest representation, 'f' for magnitudes in
+// [1e-6, 1e21) or zero and 'g' otherwise
+func (c *config) formatFloat(f float64, bitSize int) string {
+	if c.floatFormat != FloatAuto {
+		return strconv.FormatFloat(f, 'f', c.floatPrecision, 64)
+	}
+	if abs := math.Abs(f); abs == 0 || abs >= 1e-6 && abs < 1e21 {
+		return strconv.FormatFloat(f, 'f', -1, bitSize)
+	}
+	return strconv.FormatFloat(f, 'g', -1, bitSize)
+}
@@ -0,0 +1,1521 @@
+package struct2csv
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Option configures how WriteCSV serializes data
+type Option func(*config)
+
+// config holds the settings collected from the given options
+type config struct {
+	catchAllHeader string
+	catchAllField  string
+	catchAllIndex  int
+	elemType       reflect.Type
+
+	numberGrouping bool
+	locale         *Locale
+
+	etag         string
+	lastModified time.Time
+
+	strict    bool
+	errorCell string
+
+	quote rune
+
+	trimStrings bool
+
+	trueString  string
+	falseString string
+
+	filenameFunc func(data any) string
+
+	enumLabels map[string]map[int64]string
+
+	bitmasks          map[string]bitmask
+	skipUnlabeledBits bool
+
+	observer func(Stats)
+
+	interfaceResolver func(reflect.Value) reflect.Type
+
+	quoteEmptyStrings bool
+
+	noDisposition bool
+
+	padOverflow PadOverflow
+
+	ctx         context.Context
+	parallelism int
+
+	flushEvery int
+	autoFlush  bool
+
+	profile string
+
+	diffBaseline any
+	diffBase     reflect.Value
+	diffMode     DiffMode
+
+	mapSeparator   string
+	sliceSeparator string
+
+	bom         bool
+	commentFunc func(elemType reflect.Type) string
+
+	headers []string
+
+	jsonFields map[string]bool
+
+	fieldFormatters map[string]formatter
+	typeFormatters  map[reflect.Type]formatter
+
+	trimEmptyColumns bool
+	blankZeroNumbers bool
+	blankZeroTimes   bool
+	numberStringers  bool
+	replacer         *strings.Replacer
+	typeHeaderRow    bool
+	nilStructMode    NilStructMode
+
+	rowFilter       func(v any) bool
+	rowNumbers      bool
+	rowNumberHeader string
+	inputRowNumbers bool
+
+	headerTranslator func(key string) string
+	quotePredicate   func(header, value string) bool
+
+	timeLayout      string
+	timeFormatter   func(t time.Time) string
+	headerSeparator string
+
+	ranged             bool
+	rangeOffset        int
+	rangeLimit         int
+	rangeOffsetNumbers bool
+
+	floatPrecision int
+	floatFormat    FloatFormat
+	unitsRow       bool
+
+	requiredColumns []string
+
+	gzip      bool
+	gzipLevel int
+
+	escapeNewlines bool
+	newlineToken   string
+
+	recordHook func(record []string) ([]string, error)
+
+	ignoreValue string
+	emptyInput  EmptyInputBehavior
+
+	groupedHeaders bool
+
+	csvWriter func(w *csv.Writer)
+
+	mapValueMode MapValueMode
+
+	sortBy []string
+
+	skipHeader bool
+	sink       func(record []string) error
+
+	maxCellLength    int
+	truncationMarker string
+
+	checksumTrailer bool
+
+	transpose bool
+	eofMarker string
+
+	typeHint reflect.Type
+
+	stripReplacementChar bool
+	stripValueBOM        bool
+
+	columnRenames map[string]string
+
+	collectErrors bool
+	errorRowValue string
+	rowErrors     []error
+
+	explodeField     string
+	explodeIndex     int
+	explodeType      reflect.Type
+	childIndex       bool
+	childIndexHeader string
+}
+
+// defaultOptions are the options SetDefaultOptions set
+var defaultOptions struct {
+	sync.RWMutex
+	opts []Option
+}
+
+// SetDefaultOptions sets options applied before the options of every call,
+// which override them, for app-wide settings such as WithTimeLayout, it
+// replaces the previous defaults and is safe for concurrent use
+//
+//	func init() {
+//		struct2csv.SetDefaultOptions(struct2csv.WithBOM())
+//	}
+func SetDefaultOptions(opts ...Option) {
+	defaultOptions.Lock()
+	defer defaultOptions.Unlock()
+	defaultOptions.opts = slices.Clone(opts)
+}
+
+// newConfig applies the SetDefaultOptions options and then opts over the
+// default settings
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		catchAllIndex:   -1,
+		explodeIndex:    -1,
+		trueString:      "true",
+		falseString:     "false",
+		autoFlush:       true,
+		mapSeparator:    ";",
+		sliceSeparator:  "|",
+		timeLayout:      "2006-01-02 15:04",
+		headerSeparator: ".",
+		floatPrecision:  -1,
+		newlineToken:    `\n`,
+		ignoreValue:     "-",
+	}
+	defaultOptions.RLock()
+	defaults := defaultOptions.opts
+	defaultOptions.RUnlock()
+	for _, opt := range defaults {
+		opt(cfg)
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// bind resolves the options that depend on the slice element type
+func (c *config) bind(elemType reflect.Type) error {
+	c.elemType = elemType
+	if err := c.bindDiffBaseline(elemType); err != nil {
+		return err
+	}
+	if err := c.bindExplode(elemType); err != nil {
+		return err
+	}
+	if c.catchAllField == "" {
+		return nil
+	}
+	field, ok := elemType.FieldByName(c.catchAllField)
+	if !ok || len(field.Index) != 1 {
+		return fmt.Errorf(
+			"catch-all field %q not found in %s",
+			c.catchAllField,
+			elemType,
+		)
+	}
+	if !field.IsExported() {
+		return fmt.Errorf("catch-all field %q is not exported", field.Name)
+	}
+	c.catchAllIndex = field.Index[0]
+	return nil
+}
+
+// bindDiffBaseline resolves the WithDiffBaseline baseline to a value of
+// elemType
+func (c *config) bindDiffBaseline(elemType reflect.Type) error {
+	if c.diffBaseline == nil {
+		return nil
+	}
+	base, ok := indirect(reflect.ValueOf(c.diffBaseline))
+	if !ok {
+		return errors.New("diff baseline is nil")
+	}
+	if base.Type() != elemType {
+		return fmt.Errorf(
+			"diff baseline is %s, expected %s",
+			base.Type(),
+			elemType,
+		)
+	}
+	c.diffBase = base
+	return nil
+}
+
+// buffered reports whether all rows must be formatted before any is written
+func (c *config) buffered() bool {
+	return c.omitUnchanged() || c.trimEmptyColumns || len(c.sortBy) > 0 ||
+		c.transpose
+}
+
+// omitUnchanged reports whether columns equal to the baseline are left out
+func (c *config) omitUnchanged() bool {
+	return c.diffBaseline != nil && c.diffMode == DiffOmit
+}
+
+// cellError resolves a formatValue error, under strict mode it is returned,
+// as is a pad overflow, otherwise unsupported types are written as
+// nullString and any other error as the error cell
+func (c *config) cellError(err error) (string, error) {
+	switch {
+	case c.strict, errors.Is(err, ErrPadOverflow):
+		return "", err
+	case errors.Is(err, ErrUnsupportedType):
+		return nullString, nil
+	default:
+		return c.errorCell, nil
+	}
+}
+
+// isJSONField reports whether the field at path is written as a JSON cell,
+// by its inline=json tag, WithJSONFields or NilStructCollapse
+func (c *config) isJSONField(field reflect.StructField, path string) bool {
+	return isInlineJSON(field) ||
+		c.jsonFields[path] ||
+		(c.isPointerStruct(field) && c.collapseStruct(field))
+}
+
+// expandStruct reports whether the field at path is a sub-struct whose
+// fields are written as columns, not a single JSON or formatter cell
+func (c *config) expandStruct(field reflect.StructField, path string) bool {
+	if c.jsonFields[path] {
+		return false
+	}
+	if _, ok := c.fieldFormatters[path]; ok {
+		return false
+	}
+	if c.isPointerStruct(field) {
+		return !c.collapseStruct(field)
+	}
+	return isSubStruct(field) && !c.hasTypeFormatter(field.Type)
+}
+
+// collapseStruct reports whether a pointer sub-struct field is written as
+// one column, embedded ones without a csv name have no header to use
+func (c *config) collapseStruct(field reflect.StructField) bool {
+	return c.nilStructMode == NilStructCollapse && !isEmbeddedStruct(field)
+}
+
+// isPointerStruct reports whether field is a pointer to a sub-struct, which
+// is expanded or collapsed by the WithNilStructMode mode
+func (c *config) isPointerStruct(field reflect.StructField) bool {
+	t := field.Type
+	return t.Kind() == reflect.Ptr &&
+		isSubStructType(t.Elem()) &&
+		!isInlineJSON(field) &&
+		!c.hasTypeFormatter(t) &&
+		!c.hasTypeFormatter(t.Elem())
+}
+
+// hasTypeFormatter reports whether a WithTypeFormatter formatter is set for t
+func (c *config) hasTypeFormatter(t reflect.Type) bool {
+	_, ok := c.typeFormatters[t]
+	return ok
+}
+
+// isTextType reports whether values of t are written with their MarshalText
+// or String method, numeric types only under WithNumberStringers
+func (c *config) isTextType(t reflect.Type) bool {
+	return implementsText(t) && (c.numberStringers || !isNumberKind(t.Kind()))
+}
+
+// formatType formats value with the WithTypeFormatter formatter of its type,
+// ok is false when there is none
+func (c *config) formatType(value reflect.Value) (string, bool, error) {
+	fn, ok := c.typeFormatters[value.Type()]
+	if !ok {
+		return "", false, nil
+	}
+	cell, err := fn(c.context(), value)
+	return cell, true, err
+}
+
+// translateHeader returns the WithHeaderTranslator translation of the csv
+// tag name key, the key itself when it has none
+func (c *config) translateHeader(key string) string {
+	if c.headerTranslator == nil || key == "" {
+		return key
+	}
+	if header := c.headerTranslator(key); header != "" {
+		return header
+	}
+	return key
+}
+
+// window returns the bounds of the WithRange window of a slice of n
+// elements, the whole slice when no range is set
+func (c *config) window(n int) (lo, hi int) {
+	if !c.ranged {
+		return 0, n
+	}
+	lo = min(max(c.rangeOffset, 0), n)
+	hi = n
+	if c.rangeLimit > 0 {
+		hi = min(lo+c.rangeLimit, n)
+	}
+	return lo, hi
+}
+
+// inWindow reports whether the element at index i is in the WithRange
+// window, past is true once i is after the window
+func (c *config) inWindow(i int) (in, past bool) {
+	if !c.ranged {
+		return true, false
+	}
+	if c.rangeLimit > 0 && i >= c.rangeOffset+c.rangeLimit {
+		return false, true
+	}
+	return i >= c.rangeOffset, false
+}
+
+// firstRowNumber returns the WithRowNumbers number of the first row
+func (c *config) firstRowNumber() int {
+	if c.ranged && c.rangeOffsetNumbers {
+		return max(c.rangeOffset, 0) + 1
+	}
+	return 1
+}
+
+// inputIndex returns the index in the input of element i of the slice
+// WithRange windowed
+func (c *config) inputIndex(i int) int {
+	if !c.ranged {
+		return i
+	}
+	return max(c.rangeOffset, 0) + i
+}
+
+// keepRow reports whether the slice element elem is written, by the
+// WithRowFilter filter
+func (c *config) keepRow(elem reflect.Value) bool {
+	return c.rowFilter == nil || c.rowFilter(elem.Interface())
+}
+
+// replace applies WithEscapeNewlines, the WithValueReplacer replacer and
+// then WithMaxCellLength to a formatted cell
+func (c *config) replace(cell string) string {
+	if c.escapeNewlines && strings.ContainsAny(cell, "\r\n") {
+		cell = newlineReplacer(c.newlineToken).Replace(cell)
+	}
+	if c.replacer != nil {
+		cell = c.replacer.Replace(cell)
+	}
+	if c.maxCellLength > 0 {
+		cell = truncateCell(cell, c.maxCellLength, c.truncationMarker)
+	}
+	return cell
+}
+
+// truncateCell shortens a cell longer than n runes to n runes ending with
+// marker, cut to n runes itself when longer, it never splits a rune
+func truncateCell(cell string, n int, marker string) string {
+	if utf8.RuneCountInString(cell) <= n {
+		return cell
+	}
+	markerRunes := []rune(marker)
+	if len(markerRunes) > n {
+		return string(markerRunes[:n])
+	}
+	runes := []rune(cell)
+	return string(runes[:n-len(markerRunes)]) + marker
+}
+
+// newlineReplacer replaces the line breaks of a cell with token
+func newlineReplacer(token string) *strings.Replacer {
+	return strings.NewReplacer("\r\n", token, "\r", token, "\n", token)
+}
+
+// leadingComment returns the comment line written before the header, empty
+// when none is set
+func (c *config) leadingComment(elemType reflect.Type) string {
+	if c.commentFunc == nil {
+		return ""
+	}
+	return c.commentFunc(elemType)
+}
+
+// filename returns the download filename for data
+func (c *config) filename(filename string, data any) string {
+	if c.filenameFunc != nil {
+		return c.filenameFunc(data)
+	}
+	return filename
+}
+
+// resolveInterface returns the struct type used for the columns of an
+// interface element, its concrete type unless a resolver is set
+func (c *config) resolveInterface(elem reflect.Value) (reflect.Type, error) {
+	if c.interfaceResolver != nil {
+		t := c.interfaceResolver(elem)
+		if t == nil {
+			return nil, errors.New("interface resolver returned no type")
+		}
+		return t, nil
+	}
+	if elem.IsNil() {
+		return nil, errors.New("element is nil")
+	}
+	t := elem.Elem().Type()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t, nil
+}
+
+// context returns the context of WriteContext or WithContext, the
+// background context when none is set
+func (c *config) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// ctxErr returns the error of the context once it is done
+func (c *config) ctxErr() error {
+	if c.ctx == nil {
+		return nil
+	}
+	return c.ctx.Err()
+}
+
+// formatBool formats b with the configured bool strings
+func (c *config) formatBool(b bool) string {
+	if b {
+		return c.trueString
+	}
+	return c.falseString
+}
+
+// skipField reports whether field i of elemType is left out of the columns
+func (c *config) skipField(elemType reflect.Type, i int) bool {
+	field := elemType.Field(i)
+	return isIgnoredField(field, c.ignoreValue) ||
+		c.isCatchAll(elemType, i) ||
+		c.isExploded(elemType, i) ||
+		!c.inProfile(field)
+}
+
+// inProfile reports whether field is written under the WithProfile profile,
+// fields without a csv_profiles tag are part of every profile
+func (c *config) inProfile(field reflect.StructField) bool {
+	if c.profile == "" {
+		return true
+	}
+	profiles, ok := field.Tag.Lookup("csv_profiles")
+	if !ok {
+		return true
+	}
+	for _, profile := range strings.Split(profiles, ",") {
+		if strings.TrimSpace(profile) == c.profile {
+			return true
+		}
+	}
+	return false
+}
+
+// isCatchAll reports whether field i of elemType is the catch-all field
+func (c *config) isCatchAll(elemType reflect.Type, i int) bool {
+	return c.catchAllIndex == i && c.elemType == elemType
+}
+
+// WithCatchAll moves the top level map/interface field named fieldName out
+// of its position and writes it as compact JSON in a single trailing column
+// named headerName
+//
+//	type Event struct {
+//		Name  string         `csv:"الاسم"`
+//		Extra map[string]any `csv:"-"`
+//	}
+//
+//	WriteCSV(h, w, "events.csv", events, WithCatchAll("extra", "Extra"))
+func WithCatchAll(headerName, fieldName string) Option {
+	return func(c *config) {
+		c.catchAllHeader = headerName
+		c.catchAllField = fieldName
+	}
+}
+
+// WithNumberGrouping inserts the locale grouping separator between every
+// three integer digits of int, uint and float values (1,234,567.89), it is
+// off by default to keep numbers machine readable
+func WithNumberGrouping(enabled bool) Option {
+	return func(c *config) {
+		c.numberGrouping = enabled
+	}
+}
+
+// WithLocale shapes the digits and separators of int, uint and float values
+// using locale, for example LocaleArabic writes ١٬٢٣٤٫٥
+func WithLocale(locale Locale) Option {
+	return func(c *config) {
+		c.locale = &locale
+	}
+}
+
+// WithETag sets the ETag response header, an unquoted etag is quoted
+func WithETag(etag string) Option {
+	return func(c *config) {
+		if etag != "" &&
+			!strings.HasPrefix(etag, `"`) &&
+			!strings.HasPrefix(etag, `W/"`) {
+			etag = `"` + etag + `"`
+		}
+		c.etag = etag
+	}
+}
+
+// WithLastModified sets the Last-Modified response header
+func WithLastModified(t time.Time) Option {
+	return func(c *config) {
+		c.lastModified = t
+	}
+}
+
+// WithStrict makes cell level failures abort the export, such as a
+// MarshalCSV error or a field kind with no csv representation, by default
+// they are written as the error cell and nullString respectively
+func WithStrict(strict bool) Option {
+	return func(c *config) {
+		c.strict = strict
+	}
+}
+
+// WithErrorCellString sets the cell written in place of a value that failed
+// to format when strict mode is off, so failures can be found in the export
+// without aborting it, the default is an empty cell
+func WithErrorCellString(s string) Option {
+	return func(c *config) {
+		c.errorCell = s
+	}
+}
+
+// WithQuote sets the character used to quote fields instead of '"', a quote
+// inside a quoted field is escaped by doubling it
+func WithQuote(quote rune) Option {
+	return func(c *config) {
+		c.quote = quote
+	}
+}
+
+// WithTrimStrings removes leading and trailing white space from string values
+func WithTrimStrings(trim bool) Option {
+	return func(c *config) {
+		c.trimStrings = trim
+	}
+}
+
+// WithBoolStrings sets the cells written for true and false, it applies to
+// bool and *bool fields where a nil *bool is still written as nullString
+//
+//	WithBoolStrings("نعم", "لا")
+func WithBoolStrings(trueString, falseString string) Option {
+	return func(c *config) {
+		c.trueString = trueString
+		c.falseString = falseString
+	}
+}
+
+// WithFilenameFunc derives the download filename from the data being
+// written, overriding the filename given to WriteCSV
+//
+//	WithFilenameFunc(func(data any) string {
+//		return "orders_" + time.Now().Format("2006-01") + ".csv"
+//	})
+func WithFilenameFunc(fn func(data any) string) Option {
+	return func(c *config) {
+		c.filenameFunc = fn
+	}
+}
+
+// WithEnumLabels writes the label of an integer field value instead of the
+// number, values without a label are still written as numbers, fieldName is
+// the Go field name or a dotted path for nested fields like "User.Status"
+//
+//	WithEnumLabels("Status", map[int64]string{1: "نشط", 2: "موقوف"})
+func WithEnumLabels(fieldName string, labels map[int64]string) Option {
+	return func(c *config) {
+		if c.enumLabels == nil {
+			c.enumLabels = make(map[string]map[int64]string)
+		}
+		c.enumLabels[fieldName] = labels
+	}
+}
+
+// WithBitmaskLabels writes an integer field holding a bitmask as the labels
+// of its set bits joined by separator, labels maps a bit like 1<<2 to its
+// label, a bit without one is written as bit<N> counting from 0 unless
+// WithBitmaskSkipUnlabeled is set, zero is written as the label of 0 or
+// blank, fieldName is the Go field name or a dotted path like WithEnumLabels
+//
+//	WithBitmaskLabels("Permissions", map[uint64]string{
+//		0:      "none",
+//		1 << 0: "read",
+//		1 << 1: "write",
+//	}, "|")
+func WithBitmaskLabels(
+	fieldName string,
+	labels map[uint64]string,
+	separator string,
+) Option {
+	return func(c *config) {
+		if c.bitmasks == nil {
+			c.bitmasks = make(map[string]bitmask)
+		}
+		c.bitmasks[fieldName] = bitmask{labels: labels, separator: separator}
+	}
+}
+
+// WithBitmaskSkipUnlabeled leaves the set bits without a WithBitmaskLabels
+// label out instead of writing them as bit<N>
+func WithBitmaskSkipUnlabeled(skip bool) Option {
+	return func(c *config) {
+		c.skipUnlabeledBits = skip
+	}
+}
+
+// WithObserver calls fn once after the data is written, also when writing
+// fails in which case Stats holds the partial counts
+func WithObserver(fn func(stats Stats)) Option {
+	return func(c *config) {
+		c.observer = fn
+	}
+}
+
+// WithInterfaceResolver sets how the struct type of each element of a slice
+// of interfaces is determined, every element must resolve to the same type
+// and hold a value of, or a pointer to, or convertible to that type
+func WithInterfaceResolver(fn func(elem reflect.Value) reflect.Type) Option {
+	return func(c *config) {
+		c.interfaceResolver = fn
+	}
+}
+
+// WithQuoteEmptyStrings writes empty string values as a quoted empty field
+// "" so they can be told apart from nil pointers which stay bare
+func WithQuoteEmptyStrings(enabled bool) Option {
+	return func(c *config) {
+		c.quoteEmptyStrings = enabled
+	}
+}
+
+// WithoutDisposition stops WriteCSV from setting the Content-Disposition
+// attachment header, for APIs returning csv as the response body
+func WithoutDisposition() Option {
+	return func(c *config) {
+		c.noDisposition = true
+	}
+}
+
+// PadOverflow is how a value longer than the width of its pad tag option is
+// handled
+type PadOverflow int
+
+const (
+	// PadOverflowError fails the write with an error wrapping
+	// ErrPadOverflow, with or without WithStrict, the default
+	PadOverflowError PadOverflow = iota
+
+	// PadOverflowTruncate cuts the value to the pad width
+	PadOverflowTruncate
+)
+
+// WithPadOverflow sets how values longer than their pad width are handled
+func WithPadOverflow(mode PadOverflow) Option {
+	return func(c *config) {
+		c.padOverflow = mode
+	}
+}
+
+// NilStructMode is how a field holding a pointer to a sub-struct is written
+type NilStructMode int
+
+const (
+	// NilStructExpand writes the sub-struct fields as columns like a
+	// sub-struct, blank when the pointer is nil, the default, a pointer back
+	// to a struct type already expanded like a Parent *T field of T is one
+	// blank column
+	NilStructExpand NilStructMode = iota
+
+	// NilStructCollapse writes the sub-struct as one JSON column named by
+	// the field header, blank when the pointer is nil, this changes the
+	// column count from the sub-struct fields to one
+	NilStructCollapse
+)
+
+// WithNilStructMode sets how pointer sub-struct fields are written, the
+// mode picks the columns so it applies whether or not the pointers are nil,
+// embedded pointer structs without a csv name are always expanded
+func WithNilStructMode(mode NilStructMode) Option {
+	return func(c *config) {
+		c.nilStructMode = mode
+	}
+}
+
+// EmptyInputBehavior is what is written for an empty slice
+type EmptyInputBehavior int
+
+const (
+	// EmptyInputHeaderOnly writes the header without rows, the default
+	EmptyInputHeaderOnly EmptyInputBehavior = iota
+
+	// EmptyInputFile writes nothing, not even a BOM, under WithGzip the
+	// file is an empty gzip stream so a gzip encoded response still decodes
+	EmptyInputFile
+
+	// EmptyInputError writes nothing and returns ErrEmptyInput
+	EmptyInputError
+)
+
+// WithEmptyInputBehavior sets what is written when the slice has no
+// elements, rows left out by WithRowFilter or WithRange don't make it empty
+func WithEmptyInputBehavior(behavior EmptyInputBehavior) Option {
+	return func(c *config) {
+		c.emptyInput = behavior
+	}
+}
+
+// WithParallelism formats rows on n goroutines while still writing them in
+// order, n <= 1 formats rows sequentially, Marshaler implementations must be
+// safe for concurrent use
+func WithParallelism(n int) Option {
+	return func(c *config) {
+		c.parallelism = n
+	}
+}
+
+// WithFlushEvery flushes the written records through to the io.Writer after
+// every n rows, calling its Flush method when it has one such as an
+// http.ResponseWriter, so a streamed response reaches the client early
+func WithFlushEvery(n int) Option {
+	return func(c *config) {
+		c.flushEvery = n
+	}
+}
+
+// WithAutoFlush sets whether the io.Writer is flushed once everything is
+// written, on by default, turn it off when the caller flushes it, records
+// buffered by the package are always written to the io.Writer
+func WithAutoFlush(enabled bool) Option {
+	return func(c *config) {
+		c.autoFlush = enabled
+	}
+}
+
+// WithProfile writes only the fields whose csv_profiles tag lists profile,
+// along with the fields that have no csv_profiles tag
+//
+//	type User struct {
+//		Name  string `csv:"الاسم"`
+//		Email string `csv:"الايميل" csv_profiles:"admin,full"`
+//		Notes string `csv:"ملاحظات" csv_profiles:"full"`
+//	}
+func WithProfile(profile string) Option {
+	return func(c *config) {
+		c.profile = profile
+	}
+}
+
+// DiffMode is how WithDiffBaseline writes the columns equal to the baseline
+type DiffMode int
+
+const (
+	// DiffBlank writes a blank cell for every field equal to the baseline
+	DiffBlank DiffMode = iota
+
+	// DiffOmit is DiffBlank also leaving out the columns that are equal to
+	// the baseline in every row, this formats all rows before writing
+	DiffOmit
+)
+
+// WithDiffBaseline compares every field of a row to the same field of
+// baseline, a value of or pointer to the slice element type, with
+// reflect.DeepEqual and writes only the changed ones, see DiffMode
+//
+//	WithDiffBaseline(before, struct2csv.DiffBlank)
+func WithDiffBaseline(baseline any, mode DiffMode) Option {
+	return func(c *config) {
+		c.diffBaseline = baseline
+		c.diffMode = mode
+	}
+}
+
+// WithMapSeparator sets the separator between the key=value pairs of a map
+// cell, ";" by default
+func WithMapSeparator(sep string) Option {
+	return func(c *config) {
+		c.mapSeparator = sep
+	}
+}
+
+// WithSliceSeparator sets the separator between the elements of a slice
+// cell, "|" by default
+func WithSliceSeparator(sep string) Option {
+	return func(c *config) {
+		c.sliceSeparator = sep
+	}
+}
+
+// WithBOM writes a UTF-8 byte order mark before anything else, for
+// spreadsheet apps that need it to detect the encoding
+func WithBOM() Option {
+	return func(c *config) {
+		c.bom = true
+	}
+}
+
+// WithLeadingComment writes comment as a raw line before the header, after
+// the BOM, csv has no comments so it's written as is, e.g. "# users export"
+func WithLeadingComment(comment string) Option {
+	return WithLeadingCommentFunc(func(reflect.Type) string {
+		return comment
+	})
+}
+
+// WithLeadingCommentFunc is WithLeadingComment with the comment built from
+// the struct type of the rows, which is nil for WriteSQLRows
+//
+//	struct2csv.WithLeadingCommentFunc(func(t reflect.Type) string {
+//		return fmt.Sprintf("# struct: %s, generated: %s", t.Name(), now)
+//	})
+func WithLeadingCommentFunc(fn func(elemType reflect.Type) string) Option {
+	return func(c *config) {
+		c.commentFunc = fn
+	}
+}
+
+// WithHeaders writes headers instead of the headers derived from the struct
+// tags, rows are still written in struct order so headers must have one
+// entry per column
+func WithHeaders(headers []string) Option {
+	return func(c *config) {
+		c.headers = headers
+	}
+}
+
+// WithJSONFields writes the fields at paths as compact JSON cells whatever
+// their kind, like the inline=json tag, paths are Go field names or dotted
+// paths for nested fields like "User.Roles"
+func WithJSONFields(paths []string) Option {
+	return func(c *config) {
+		if c.jsonFields == nil {
+			c.jsonFields = make(map[string]bool, len(paths))
+		}
+		for _, path := range paths {
+			c.jsonFields[path] = true
+		}
+	}
+}
+
+// WithFieldFormatter formats the field at path with fn instead of the tag and
+// type based formatting, path is the Go field name or a dotted path for
+// nested fields like "Order.Amount"
+//
+//	struct2csv.WithFieldFormatter("Order.Amount", func(v reflect.Value) (string, error) {
+//		return strconv.FormatFloat(v.Float(), 'f', 2, 64), nil
+//	})
+func WithFieldFormatter(
+	path string,
+	fn func(reflect.Value) (string, error),
+) Option {
+	return WithFieldFormatterContext(path, ignoreContext(fn))
+}
+
+// WithFieldFormatterContext is WithFieldFormatter with fn receiving the
+// context of WriteContext or WithContext, e.g. for a per-request locale
+func WithFieldFormatterContext(
+	path string,
+	fn func(ctx context.Context, v reflect.Value) (string, error),
+) Option {
+	return func(c *config) {
+		if c.fieldFormatters == nil {
+			c.fieldFormatters = make(map[string]formatter)
+		}
+		c.fieldFormatters[path] = fn
+	}
+}
+
+// WithTypeFormatter formats every value of type t with fn, at any depth and
+// inside slices and maps, it takes precedence over MarshalCSV and a struct
+// type is written as one column, which covers wrappers like optional times
+//
+//	type OptTime struct {
+//		Time time.Time
+//		Set  bool
+//	}
+//
+//	struct2csv.WithTypeFormatter(
+//		reflect.TypeOf(OptTime{}),
+//		func(v reflect.Value) (string, error) {
+//			t := v.Interface().(OptTime)
+//			if !t.Set {
+//				return "", nil
+//			}
+//			return t.Time.Format(time.DateOnly), nil
+//		},
+//	)
+func WithTypeFormatter(
+	t reflect.Type,
+	fn func(reflect.Value) (string, error),
+) Option {
+	return WithTypeFormatterContext(t, ignoreContext(fn))
+}
+
+// WithTypeFormatterContext is WithTypeFormatter with fn receiving the
+// context of WriteContext or WithContext
+func WithTypeFormatterContext(
+	t reflect.Type,
+	fn func(ctx context.Context, v reflect.Value) (string, error),
+) Option {
+	return func(c *config) {
+		if c.typeFormatters == nil {
+			c.typeFormatters = make(map[reflect.Type]formatter)
+		}
+		c.typeFormatters[t] = fn
+	}
+}
+
+// formatter formats a value for WithFieldFormatterContext and
+// WithTypeFormatterContext
+type formatter func(ctx context.Context, v reflect.Value) (string, error)
+
+// ignoreContext adapts a formatter that does not need the context
+func ignoreContext(fn func(reflect.Value) (string, error)) formatter {
+	return func(_ context.Context, v reflect.Value) (string, error) {
+		return fn(v)
+	}
+}
+
+// WithContext sets the context passed to context-aware formatters, writing
+// stops with ctx.Err() once it is done like WriteContext
+//
+//	struct2csv.Write(w, rows, struct2csv.WithContext(r.Context()))
+func WithContext(ctx context.Context) Option {
+	return func(c *config) {
+		c.ctx = ctx
+	}
+}
+
+// WithTrimEmptyColumns drops the trailing columns that are empty in every
+// row from the header and rows, all rows are formatted before any is written
+func WithTrimEmptyColumns(trim bool) Option {
+	return func(c *config) {
+		c.trimEmptyColumns = trim
+	}
+}
+
+// WithBlankZeroNumbers writes zero int, uint and float values as nullString
+// instead of "0"
+func WithBlankZeroNumbers(blank bool) Option {
+	return func(c *config) {
+		c.blankZeroNumbers = blank
+	}
+}
+
+// WithBlankZeroTimes writes zero time values as nullString instead of
+// formatting them with the WithTimeLayout layout or WithTimeFormatter
+func WithBlankZeroTimes(blank bool) Option {
+	return func(c *config) {
+		c.blankZeroTimes = blank
+	}
+}
+
+// WithNumberStringers writes numeric types with a MarshalText or String
+// method, like an int enum, with the method instead of as numbers, which
+// WithNumberGrouping, WithFloatPrecision and WithBlankZeroNumbers then leave
+// alone, time.Duration included
+func WithNumberStringers(use bool) Option {
+	return func(c *config) {
+		c.numberStringers = use
+	}
+}
+
+// WithValueReplacer applies r to every cell once formatted, after the pad
+// and exceltext tag options and WithEscapeNewlines and before
+// WithMaxCellLength truncates it, e.g. to redact a domain in every column
+//
+//	struct2csv.WithValueReplacer(strings.NewReplacer("@internal.example", "@…"))
+func WithValueReplacer(r *strings.Replacer) Option {
+	return func(c *config) {
+		c.replacer = r
+	}
+}
+
+// WithTypeHeaderRow writes a second header row with the type of every
+// column for typed importers: "string", "int", "float", "bool", "datetime"
+// or "json"
+func WithTypeHeaderRow(typeRow bool) Option {
+	return func(c *config) {
+		c.typeHeaderRow = typeRow
+	}
+}
+
+// WithRowFilter writes only the elements for which keep returns true, it's
+// called with each element as it is in the slice, the header is written
+// either way
+func WithRowFilter(keep func(v any) bool) Option {
+	return func(c *config) {
+		c.rowFilter = keep
+	}
+}
+
+// WithRowNumbers adds a first column named header numbering the rows from 1,
+// rows left out by WithRowFilter are not counted unless useOriginalIndex
+// is set, then every row is numbered by its index in the input from 1, to
+// reconcile rows with the source data
+func WithRowNumbers(header string, useOriginalIndex bool) Option {
+	return func(c *config) {
+		c.rowNumbers = true
+		c.rowNumberHeader = header
+		c.inputRowNumbers = useOriginalIndex
+	}
+}
+
+// WithHeaderTranslator treats csv tag names as keys translated by translate,
+// a key translated to "" is written as is, nested headers are joined after
+// translating each name
+//
+//	struct2csv.WithHeaderTranslator(func(key string) string {
+//		return labels[lang][key]
+//	})
+func WithHeaderTranslator(translate func(key string) string) Option {
+	return func(c *config) {
+		c.headerTranslator = translate
+	}
+}
+
+// WithQuotePredicate quotes the cells for which quote returns true given
+// their column header, other cells are quoted only when they need to be
+//
+//	struct2csv.WithQuotePredicate(func(header, value string) bool {
+//		return header == "notes"
+//	})
+func WithQuotePredicate(quote func(header, value string) bool) Option {
+	return func(c *config) {
+		c.quotePredicate = quote
+	}
+}
+
+// WithTimeLayout sets the time.Format layout of time cells, including times
+// in slices, maps and sub-structs, "2006-01-02 15:04" by default
+func WithTimeLayout(layout string) Option {
+	return func(c *config) {
+		c.timeLayout = layout
+	}
+}
+
+// WithTimeFormatter formats time cells with format instead of the
+// WithTimeLayout layout, for month and day names time.Format can't
+// localize, nil pointers are written as nullString without calling it, as
+// are zero times under WithBlankZeroTimes
+//
+//	WithTimeFormatter(func(t time.Time) string {
+//		return fmt.Sprintf("%d %s %d", t.Day(), arabicMonths[t.Month()-1], t.Year())
+//	})
+func WithTimeFormatter(format func(t time.Time) string) Option {
+	return func(c *config) {
+		c.timeFormatter = format
+	}
+}
+
+// formatTime formats a time cell with the WithTimeFormatter formatter or
+// the WithTimeLayout layout, a zero time is nullString under
+// WithBlankZeroTimes
+func (c *config) formatTime(t time.Time) string {
+	if c.blankZeroTimes && t.IsZero() {
+		return nullString
+	}
+	if c.timeFormatter == nil {
+		return t.Format(c.timeLayout)
+	}
+	return c.timeFormatter(t)
+}
+
+// WithHeaderSeparator sets the separator joining the header of a sub-struct
+// field to the headers of its fields, "." by default
+func WithHeaderSeparator(sep string) Option {
+	return func(c *config) {
+		c.headerSeparator = sep
+	}
+}
+
+// WithRange writes only the rows [offset, offset+limit) of the slice, to
+// its end when limit <= 0, the header is written even when offset is past
+// the last row
+func WithRange(offset, limit int) Option {
+	return func(c *config) {
+		c.ranged = true
+		c.rangeOffset = offset
+		c.rangeLimit = limit
+	}
+}
+
+// WithRangeOffsetNumbers numbers the rows of a WithRange window from
+// offset+1 instead of 1 with WithRowNumbers
+func WithRangeOffsetNumbers(fromOffset bool) Option {
+	return func(c *config) {
+		c.rangeOffsetNumbers = fromOffset
+	}
+}
+
+// WithFloatPrecision writes floats with prec digits after the decimal point,
+// -1 the default writes the fewest digits needed, it applies to defined
+// float types like type Money float64 too, which can be given a suffix with
+// WithTypeFormatter
+//
+//	struct2csv.WithTypeFormatter(
+//		reflect.TypeOf(Money(0)),
+//		func(v reflect.Value) (string, error) {
+//			return strconv.FormatFloat(v.Float(), 'f', 2, 64) + " SAR", nil
+//		},
+//	)
+func WithFloatPrecision(prec int) Option {
+	return func(c *config) {
+		c.floatPrecision = prec
+	}
+}
+
+// FloatFormat is how float cells are formatted
+type FloatFormat int
+
+const (
+	// FloatFixed writes floats without an exponent with the WithFloatPrecision
+	// digits after the decimal point, the default
+	FloatFixed FloatFormat = iota
+
+	// FloatAuto writes the shortest decimal that reads back as the same
+	// float, without an exponent and trailing zeros for magnitudes from
+	// 1e-6 up to 1e21 and in exponent form like 1e-07 outside them,
+	// WithFloatPrecision is ignored
+	FloatAuto
+)
+
+// WithFloatFormat sets how float cells are formatted, FloatAuto writes 1.5,
+// 1000000, 0.0001 and 3.14159 as they are
+func WithFloatFormat(format FloatFormat) Option {
+	return func(c *config) {
+		c.floatFormat = format
+	}
+}
+
+// WithUnitsRow writes a row after the header, and the WithTypeHeaderRow row,
+// with the unit tag option of every column, blank where there is none,
+// `csv:"mass,unit=kg"`
+func WithUnitsRow(unitsRow bool) Option {
+	return func(c *config) {
+		c.unitsRow = unitsRow
+	}
+}
+
+// WithRequiredColumns fails writing before any row when a header in headers
+// is not produced by the struct, to catch renamed tags breaking consumers
+func WithRequiredColumns(headers []string) Option {
+	return func(c *config) {
+		c.requiredColumns = headers
+	}
+}
+
+// WithGzip compresses the csv with gzip at level, such as
+// gzip.DefaultCompression, WriteCSV sets Content-Encoding: gzip, periodic
+// flushes flush the gzip stream too and it's closed when writing stops,
+// also when the context is done
+func WithGzip(level int) Option {
+	return func(c *config) {
+		c.gzip = true
+		c.gzipLevel = level
+	}
+}
+
+// WithEscapeNewlines replaces the line breaks inside cells with a literal \n
+// so every record stays on one line for importers that can't read quoted
+// multi-line fields, see WithNewlineToken
+func WithEscapeNewlines(escape bool) Option {
+	return func(c *config) {
+		c.escapeNewlines = escape
+	}
+}
+
+// WithNewlineToken sets the token WithEscapeNewlines replaces line breaks
+// with, `\n` by default
+func WithNewlineToken(token string) Option {
+	return func(c *config) {
+		c.newlineToken = token
+	}
+}
+
+// WithRecordHook calls hook with every record, the header rows and the data
+// rows, right before it's written, the record hook returns is written
+// instead and a nil record is skipped, an error aborts the export, skipped
+// rows are still counted by Stats
+//
+//	WithRecordHook(func(record []string) ([]string, error) {
+//		if record[0] == "internal" {
+//			return nil, nil
+//		}
+//		return record, nil
+//	})
+func WithRecordHook(hook func(record []string) ([]string, error)) Option {
+	return func(c *config) {
+		c.recordHook = hook
+	}
+}
+
+// WithIgnoreValue sets the csv tag value that leaves a field out, "-" by
+// default, to share tags with an encoder using another sentinel, "-" is then
+// an ordinary header and an empty value turns ignoring by tag off
+func WithIgnoreValue(value string) Option {
+	return func(c *config) {
+		c.ignoreValue = value
+	}
+}
+
+// WithGroupedHeaders writes the headers as two rows, the first names each
+// nested struct once above its columns and the second has the headers of
+// the columns within it, columns outside a nested struct are blank in the
+// first row
+func WithGroupedHeaders(grouped bool) Option {
+	return func(c *config) {
+		c.groupedHeaders = grouped
+	}
+}
+
+// WithCSVWriter calls configure with the *csv.Writer once it's created, to
+// set fields like Comma or UseCRLF no option covers, what configure sets
+// overrides the options, when an option needs the package's own quoting
+// writer only Comma and UseCRLF are taken from it
+//
+//	WithCSVWriter(func(w *csv.Writer) {
+//		w.Comma = ';'
+//		w.UseCRLF = true
+//	})
+func WithCSVWriter(configure func(w *csv.Writer)) Option {
+	return func(c *config) {
+		c.csvWriter = configure
+	}
+}
+
+// MapValueMode is how the struct values of a map field are written
+type MapValueMode int
+
+const (
+	// MapValueBlank leaves maps of struct values without a csv
+	// representation, they are written like other unsupported fields, the
+	// default
+	MapValueBlank MapValueMode = iota
+
+	// MapValueJSON writes every struct value of the pairs as compact JSON,
+	// home={"city":"Tripoli"}
+	MapValueJSON
+)
+
+// WithMapValueMode sets how the struct values of map fields are written,
+// maps of other values are not affected
+func WithMapValueMode(mode MapValueMode) Option {
+	return func(c *config) {
+		c.mapValueMode = mode
+	}
+}
+
+// isJSONMapValue reports whether map values of type t are written as JSON,
+// structs and pointers to structs under MapValueJSON
+func (c *config) isJSONMapValue(t reflect.Type) bool {
+	if c.mapValueMode != MapValueJSON {
+		return false
+	}
+	for t.Kind() == reflect.Ptr {
+		if c.hasTypeFormatter(t) {
+			return false
+		}
+		t = t.Elem()
+	}
+	return isSubStructType(t) && !c.hasTypeFormatter(t)
+}
+
+// WithSortBy stably sorts the rows by the columns with the given headers,
+// descending for a header prefixed with "-", ties are broken by the next
+// column, a column sorts numerically when all its cells parse as numbers
+// and lexically otherwise, the rows are buffered to be sorted
+//
+//	WithSortBy("status", "-amount")
+func WithSortBy(columns ...string) Option {
+	return func(c *config) {
+		c.sortBy = columns
+	}
+}
+
+// WithSkipHeader leaves out the header records, the header row and the
+// WithGroupedHeaders, WithTypeHeaderRow and WithUnitsRow rows, for appending
+// rows to an existing file
+func WithSkipHeader(skip bool) Option {
+	return func(c *config) {
+		c.skipHeader = skip
+	}
+}
+
+// WithMaxCellLength truncates cells longer than n runes, such as for the
+// 32767 character cell limit of Excel, to n runes ending with marker,
+// runes are never split so Arabic text stays valid UTF-8, a marker longer
+// than n is cut to n runes, n <= 0 is unlimited, the default
+//
+//	WithMaxCellLength(32767, "…")
+func WithMaxCellLength(n int, marker string) Option {
+	return func(c *config) {
+		c.maxCellLength = n
+		c.truncationMarker = marker
+	}
+}
+
+// WithChecksumTrailer ends the output with a line that is not a record,
+// "#rows=1234,sha256=...", with the number of rows and the hex SHA-256 of
+// everything written before it, the csv before gzip under WithGzip, so
+// consumers can verify the body and strip the line
+func WithChecksumTrailer(trailer bool) Option {
+	return func(c *config) {
+		c.checksumTrailer = trailer
+	}
+}
+
+// WithTranspose writes the fields as rows and the records as columns, the
+// first column has the headers and every other column is one record, so
+// there are as many columns as records plus one, it suits small exports only
+// as every row is held in memory and spreadsheets limit the column count,
+// the WithGroupedHeaders, WithTypeHeaderRow and WithUnitsRow rows are left out
+func WithTranspose(transpose bool) Option {
+	return func(c *config) {
+		c.transpose = transpose
+	}
+}
+
+// WithEOFMarker ends the output with marker as a raw line that is not a
+// record, such as "# EOF", after the WithChecksumTrailer line, which does not
+// cover it
+func WithEOFMarker(marker string) Option {
+	return func(c *config) {
+		c.eofMarker = marker
+	}
+}
+
+// WithTypeHint sets the type of the values WriteValues writes, a struct or
+// pointer to struct, so that no values still write a header
+func WithTypeHint(t reflect.Type) Option {
+	return func(c *config) {
+		c.typeHint = t
+	}
+}
+
+// WithStripReplacementChar removes the U+FFFD replacement character that
+// garbled input leaves in string values, including strings in slices and
+// maps, other kinds are not affected
+func WithStripReplacementChar(strip bool) Option {
+	return func(c *config) {
+		c.stripReplacementChar = strip
+	}
+}
+
+// WithStripValueBOM removes a leading U+FEFF byte order mark from string
+// values, as left by data read from other csv files, unlike WithBOM it's
+// about the values and not the file
+func WithStripValueBOM(strip bool) Option {
+	return func(c *config) {
+		c.stripValueBOM = strip
+	}
+}
+
+// WithColumnRename renames the columns whose derived header, "address.city"
+// for a nested one, is a key of renames to its value, other headers are
+// kept, Validate reports a new header colliding with another column
+//
+//	WithColumnRename(map[string]string{
+//		"name":         "full_name",
+//		"address.city": "city",
+//	})
+func WithColumnRename(renames map[string]string) Option {
+	return func(c *config) {
+		c.columnRenames = renames
+	}
+}
+
+// WithCollectErrors writes a row of WithErrorRowValue cells, blank by
+// default, in place of a row that fails to be formatted instead of stopping,
+// the export still completes and returns the errors of all failed rows
+// joined with errors.Join
+func WithCollectErrors(collect bool) Option {
+	return func(c *config) {
+		c.collectErrors = collect
+	}
+}
+
+// WithErrorRowValue sets the cell of every column of the rows written by
+// WithCollectErrors in place of failed ones, such as "#ERROR"
+func WithErrorRowValue(value string) Option {
+	return func(c *config) {
+		c.errorRowValue = value
+	}
+}
+
+// collectRowError keeps err, the error formatting a row, under
+// WithCollectErrors returning the row of width cells written in its place,
+// ok is false otherwise
+func (c *config) collectRowError(err error, width int) (row record, ok bool) {
+	if !c.collectErrors {
+		return record{}, false
+	}
+	c.rowErrors = append(c.rowErrors, err)
+	for range width {
+		row.add(c.errorRowValue, false)
+	}
+	return row, true
+}
+
+// joinRowErrors joins the errors collectRowError kept to err and clears
+// them
+func (c *config) joinRowErrors(err error) error {
+	if len(c.rowErrors) == 0 {
+		return err
+	}
+	err = errors.Join(append(c.rowErrors, err)...)
+	c.rowErrors = nil
+	return err
+}
+
+// WithExplode writes a row per element of the top level field named
+// fieldName, a slice or array of structs or pointers to structs, with the
+// cells of the other fields repeated on every row, the columns of the
+// element struct follow the other columns prefixed by the field header like
+// a sub-struct, a parent without elements is one row with them blank
+//
+//	type Order struct {
+//		ID    int    `csv:"id"`
+//		Items []Item `csv:"item"`
+//	}
+//
+//	Write(w, orders, WithExplode("Items"), WithExplodeIndex("childIndex"))
+func WithExplode(fieldName string) Option {
+	return func(c *config) {
+		c.explodeField = fieldName
+	}
+}
+
+// WithExplodeIndex adds a column named header before the WithExplode
+// columns with the index of the element within its parent, from 1 for every
+// parent, blank for a parent without elements
+func WithExplodeIndex(header string) Option {
+	return func(c *config) {
+		c.childIndex = true
+		c.childIndexHeader = header
+	}
+}
@@ -0,0 +1,137 @@
+package struct2csv
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// rowsPerWorker is the number of rows each worker formats per batch
+const rowsPerWorker = 64
+
+// rowResult is the rows of an element formatted by a worker, skip marks an
+// element left out by WithRowFilter and rowErr is the error extracting it
+// kept for WithCollectErrors
+type rowResult struct {
+	rows   []record
+	skip   bool
+	err    error
+	rowErr error
+}
+
+// writeRowsParallel is writeRows formatting batches of rows on
+// cfg.parallelism workers, each batch is written in order once formatted,
+// workers stop on the first error or once the context is done and are
+// always waited for before returning
+func writeRowsParallel(
+	writer *recordWriter,
+	value reflect.Value,
+	elemType reflect.Type,
+	cfg *config,
+) (int, error) {
+	ctx, cancel := context.WithCancel(cfg.context())
+	defer cancel()
+
+	batch := make([]rowResult, cfg.parallelism*rowsPerWorker)
+	rows := 0
+	for start := 0; start < value.Len(); start += len(batch) {
+		results := batch[:min(len(batch), value.Len()-start)]
+		formatBatch(ctx, cancel, results, start, value, elemType, cfg)
+		if err := cfg.ctxErr(); err != nil {
+			return rows, err
+		}
+
+		for j, result := range results {
+			i := start + j
+			if result.err != nil {
+				return rows, result.err
+			}
+			if result.skip {
+				continue
+			}
+			if result.rowErr != nil {
+				width := rowWidth(writer, cfg)
+				row, _ := cfg.collectRowError(result.rowErr, width)
+				row.index = cfg.inputIndex(i)
+				result.rows = []record{row}
+			}
+			for _, row := range result.rows {
+				if err := writeRow(writer, row, cfg); err != nil {
+					return rows, fmt.Errorf("failed to write row %d: %w", i, err)
+				}
+				rows++
+				if err := periodicFlush(writer, rows, cfg); err != nil {
+					return rows, err
+				}
+			}
+		}
+	}
+	return rows, nil
+}
+
+// formatBatch formats the rows from start into results on cfg.parallelism
+// workers, cancel is called on the first error
+func formatBatch(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	results []rowResult,
+	start int,
+	value reflect.Value,
+	elemType reflect.Type,
+	cfg *config,
+) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.parallelism; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range indexes {
+				results[j] = formatRow(value, start+j, elemType, cfg)
+				if results[j].err != nil {
+					cancel()
+				}
+			}
+		}()
+	}
+
+feed:
+	for j := range results {
+		select {
+		case <-ctx.Done():
+			break feed
+		case indexes <- j:
+		}
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// formatRow formats element i of the slice value
+func formatRow(
+	value reflect.Value,
+	i int,
+	elemType reflect.Type,
+	cfg *config,
+) rowResult {
+	if !cfg.keepRow(value.Index(i)) {
+		return rowResult{skip: true}
+	}
+	elem, err := element(value, i, elemType, cfg)
+	if err != nil {
+		return rowResult{err: err}
+	}
+	rows, err := rowRecords(elem, elemType, cfg)
+	if err != nil {
+		err = fmt.Errorf("failed to extract row %d: %w", i, err)
+		if cfg.collectErrors {
+			return rowResult{rowErr: err}
+		}
+		return rowResult{err: err}
+	}
+	for j := range rows {
+		rows[j].index = cfg.inputIndex(i)
+	}
+	return rowResult{rows: rows}
+}
@@ -0,0 +1,204 @@
+package struct2csv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"reflect"
+)
+
+// WriteSeq writes the values yielded by seq as csv to w, the header is
+// derived from T, a struct or pointer to struct, and rows are written as
+// they are yielded unless an option needs to see all rows first
+//
+//	err := struct2csv.WriteSeq(w, store.Users(ctx))
+func WriteSeq[T any](w io.Writer, seq iter.Seq[T], opts ...Option) error {
+	cfg := newConfig(opts)
+	return observe(w, cfg, func(w io.Writer) (int, error) {
+		return writeSeq(w, seq, reflect.TypeFor[T](), cfg)
+	})
+}
+
+// WriteChan writes the values received from ch as csv to w until it is
+// closed, like WriteSeq
+func WriteChan[T any](w io.Writer, ch <-chan T, opts ...Option) error {
+	return WriteSeq(w, func(yield func(T) bool) {
+		for v := range ch {
+			if !yield(v) {
+				return
+			}
+		}
+	}, opts...)
+}
+
+// WriteIter writes the values next returns as csv to w until it returns
+// false, the header is derived from the type of the first value and every
+// value must be of that type, nothing is written when there are no values,
+// it adapts any source such as sync.Map.Range
+//
+//	var users []any
+//	store.Range(func(_, v any) bool {
+//		users = append(users, v)
+//		return true
+//	})
+//	err := struct2csv.WriteIter(w, func() (any, bool) {
+//		if len(users) == 0 {
+//			return nil, false
+//		}
+//		v := users[0]
+//		users = users[1:]
+//		return v, true
+//	})
+func WriteIter(w io.Writer, next func() (any, bool), opts ...Option) error {
+	cfg := newConfig(opts)
+	first, ok := next()
+	if !ok {
+		return nil
+	}
+	if first == nil {
+		return errors.New("element 0 is nil")
+	}
+	seq := func(yield func(any) bool) {
+		if !yield(first) {
+			return
+		}
+		for {
+			v, ok := next()
+			if !ok || !yield(v) {
+				return
+			}
+		}
+	}
+	return observe(w, cfg, func(w io.Writer) (int, error) {
+		return writeSeq(w, seq, reflect.TypeOf(first), cfg)
+	})
+}
+
+// writeSeq writes the header and the rows of seq, values of valueType, to
+// w returning the number of rows written, values of an interface T are
+// unwrapped
+func writeSeq[T any](
+	w io.Writer,
+	seq iter.Seq[T],
+	valueType reflect.Type,
+	cfg *config,
+) (rows int, err error) {
+	writer, err := newRecordWriter(w, cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if flushErr := writer.finish(cfg); err == nil {
+			err = flushErr
+		}
+	}()
+
+	elemType := valueType
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return 0, errors.New("sequence values are not structs")
+	}
+	if err := cfg.bind(elemType); err != nil {
+		return 0, err
+	}
+	if err := writePreamble(writer, elemType, cfg); err != nil {
+		return 0, err
+	}
+
+	if cfg.buffered() {
+		values := reflect.MakeSlice(reflect.SliceOf(valueType), 0, 0)
+		i := 0
+		for v := range seq {
+			in, past := cfg.inWindow(i)
+			if past {
+				break
+			}
+			if in {
+				elem, err := seqValue(v, i, valueType)
+				if err != nil {
+					return 0, err
+				}
+				values = reflect.Append(values, elem)
+			}
+			i++
+		}
+		return writeBuffered(writer, values, elemType, cfg)
+	}
+
+	if err := writeHeader(writer, elemType, cfg); err != nil {
+		return 0, err
+	}
+	i := 0
+	for v := range seq {
+		if err := cfg.ctxErr(); err != nil {
+			return rows, err
+		}
+		in, past := cfg.inWindow(i)
+		if past {
+			break
+		}
+		if !in {
+			i++
+			continue
+		}
+		elem, err := seqValue(v, i, valueType)
+		if err != nil {
+			return rows, err
+		}
+		if !cfg.keepRow(elem) {
+			i++
+			continue
+		}
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				return rows, fmt.Errorf("element %d is nil", i)
+			}
+			elem = elem.Elem()
+		}
+		records, err := rowRecords(elem, elemType, cfg)
+		if err != nil {
+			err = fmt.Errorf("failed to extract row %d: %w", i, err)
+			row, ok := cfg.collectRowError(err, rowWidth(writer, cfg))
+			if !ok {
+				return rows, err
+			}
+			records = []record{row}
+		}
+		for _, row := range records {
+			row.index = i
+			if err := writeRow(writer, row, cfg); err != nil {
+				return rows, fmt.Errorf("failed to write row %d: %w", i, err)
+			}
+			rows++
+			if err := periodicFlush(writer, rows, cfg); err != nil {
+				return rows, err
+			}
+		}
+		i++
+	}
+	return rows, nil
+}
+
+// seqValue returns v, the i-th value of a sequence, as a reflect.Value of
+// valueType
+func seqValue[T any](v T, i int, valueType reflect.Type) (reflect.Value, error) {
+	value := reflect.ValueOf(&v).Elem()
+	if value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return reflect.Value{}, fmt.Errorf("element %d is nil", i)
+		}
+		value = value.Elem()
+	}
+	if value.Type() != valueType {
+		return reflect.Value{}, fmt.Errorf(
+			"element %d is %s, not %s",
+			i,
+			value.Type(),
+			valueType,
+		)
+	}
+	return value, nil
+}
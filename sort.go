@@ -0,0 +1,74 @@
+package struct2csv
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// sortKey is a WithSortBy column, the index of its header and its direction
+type sortKey struct {
+	column     int
+	descending bool
+	numeric    bool
+}
+
+// sortRows stably sorts rows by the WithSortBy columns of headers, a column
+// sorts numerically when all its non-empty cells parse as numbers, empty
+// cells sort before the others in ascending order
+func sortRows(rows []record, headers []string, cfg *config) error {
+	keys := make([]sortKey, 0, len(cfg.sortBy))
+	for _, column := range cfg.sortBy {
+		header, descending := strings.CutPrefix(column, "-")
+		i := slices.Index(headers, header)
+		if i < 0 {
+			return fmt.Errorf("unknown sort column %q", header)
+		}
+		keys = append(keys, sortKey{
+			column:     i,
+			descending: descending,
+			numeric:    isNumericColumn(rows, i),
+		})
+	}
+	slices.SortStableFunc(rows, func(a, b record) int {
+		for _, key := range keys {
+			i := key.column
+			c := compareCells(a.fields[i], b.fields[i], key.numeric)
+			if key.descending {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	return nil
+}
+
+// isNumericColumn reports whether the non-empty cells of column i of rows
+// all parse as numbers
+func isNumericColumn(rows []record, i int) bool {
+	for _, row := range rows {
+		cell := row.fields[i]
+		if cell == "" {
+			continue
+		}
+		if _, err := strconv.ParseFloat(cell, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// compareCells compares two cells of a column, as numbers when numeric
+func compareCells(a, b string, numeric bool) int {
+	if !numeric || a == "" || b == "" {
+		return strings.Compare(a, b)
+	}
+	x, _ := strconv.ParseFloat(a, 64)
+	y, _ := strconv.ParseFloat(b, 64)
+	return cmp.Compare(x, y)
+}
@@ -0,0 +1,77 @@
+package struct2csv
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// timePart is one column of a time field with the split tag option
+type timePart struct {
+	name   string
+	layout string
+}
+
+// timePartLayouts are the layouts of the named split parts, any other part
+// is a layout itself
+var timePartLayouts = map[string]string{
+	"date": "2006-01-02",
+	"time": "15:04",
+}
+
+// splitParts returns the columns of a time field with the split tag option,
+// split=date|time, nil for any other field
+func splitParts(field reflect.StructField) []timePart {
+	split, ok := fieldOptions(field).Lookup("split")
+	if !ok || split == "" {
+		return nil
+	}
+	t := field.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if !isTimeType(t) {
+		return nil
+	}
+	var parts []timePart
+	for _, name := range strings.Split(split, "|") {
+		layout, ok := timePartLayouts[name]
+		if !ok {
+			layout = name
+		}
+		parts = append(parts, timePart{name: name, layout: layout})
+	}
+	return parts
+}
+
+// splitRow appends a cell per part of the time field value to row like
+// extractRow does for a single cell, value is invalid for a field of a nil
+// pointer sub-struct
+func splitRow(
+	row *record,
+	value, base reflect.Value,
+	parts []timePart,
+	cfg *config,
+) {
+	unchanged := base.IsValid() && value.IsValid() && isUnchanged(value, base)
+	if !value.IsValid() {
+		unchanged = cfg.diffBase.IsValid() && !base.IsValid()
+	}
+	value, ok := indirect(value)
+	ok = ok && value.IsValid()
+	var t time.Time
+	if ok {
+		t = value.Convert(timeType).Interface().(time.Time)
+		ok = !cfg.blankZeroTimes || !t.IsZero()
+	}
+	for _, part := range parts {
+		switch {
+		case unchanged:
+			row.addUnchanged()
+		case !ok:
+			row.add(nullString, false)
+		default:
+			row.add(cfg.replace(t.Format(part.layout)), false)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package struct2csv
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// WriteSQLRows writes the result set of rows as csv to w without mapping it
+// to structs, the column names are the header and every row is formatted
+// like struct fields with NULL written as nullString and []byte as text, rows
+// is read to the end but not closed, the options that need all rows are not
+// supported, see ErrBufferedOption
+func WriteSQLRows(w io.Writer, rows *sql.Rows, opts ...Option) (err error) {
+	cfg := newConfig(opts)
+	if cfg.buffered() {
+		return ErrBufferedOption
+	}
+	writer, err := newRecordWriter(w, cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if flushErr := writer.finish(cfg); err == nil {
+			err = flushErr
+		}
+	}()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("failed to read columns: %w", err)
+	}
+	if err := writePreamble(writer, nil, cfg); err != nil {
+		return err
+	}
+	if err := writeHeaders(writer, nil, columns, nil, nil, cfg); err != nil {
+		return err
+	}
+
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for n := 0; rows.Next(); n++ {
+		if err := cfg.ctxErr(); err != nil {
+			return err
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("failed to scan row %d: %w", n, err)
+		}
+
+		var row record
+		for i, v := range values {
+			cell, err := formatSQLValue(v, cfg)
+			if err != nil {
+				cell, err = cfg.cellError(err)
+				if err != nil {
+					return fmt.Errorf(
+						"failed to extract row %d: column %s: %w",
+						n,
+						columns[i],
+						err,
+					)
+				}
+			}
+			row.add(cfg.replace(cell), false)
+		}
+
+		if err := writeRow(writer, row, cfg); err != nil {
+			return fmt.Errorf("failed to write row %d: %w", n, err)
+		}
+		if err := periodicFlush(writer, n+1, cfg); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read rows: %w", err)
+	}
+	return nil
+}
+
+// formatSQLValue formats a value scanned from a sql.Rows column, drivers
+// return text columns as []byte
+func formatSQLValue(v any, cfg *config) (string, error) {
+	if b, ok := v.([]byte); ok {
+		return formatValue(reflect.ValueOf(string(b)), cfg)
+	}
+	return formatValue(reflect.ValueOf(v), cfg)
+}
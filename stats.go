@@ -0,0 +1,36 @@
+package struct2csv
+
+import (
+	"io"
+	"time"
+)
+
+// Stats describes a completed write, reported to the WithObserver function
+type Stats struct {
+	// RowCount is the number of data rows written, excluding the header
+	RowCount int
+
+	// ByteCount is the number of bytes written to the underlying writer
+	ByteCount int64
+
+	// Duration is the time taken to write the data
+	Duration time.Duration
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Flush flushes the underlying writer, so WithFlushEvery and WithAutoFlush
+// still reach it behind WithObserver
+func (c *countingWriter) Flush() error {
+	return flush(c.w)
+}
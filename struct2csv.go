@@ -1,13 +1,21 @@
 package struct2csv
 
 import (
-	"encoding/csv"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // WriteCSV writes a csv response file and sets headers
@@ -16,7 +24,9 @@ import (
 //
 // Content-Disposition: attachment; filename=yourfilename
 //
-// for now it handles direct properties of struct and 1 level more Example:
+// columns are named by the csv struct tags and nested structs are written
+// as columns prefixed by their header, see the package doc for the field
+// rules and tag options, Example:
 //
 // to ignore fields give it csv tag "-"
 //
@@ -43,146 +53,1151 @@ func WriteCSV(
 	w http.ResponseWriter,
 	filename string,
 	data any,
+	opts ...Option,
 ) error {
-	// Set headers for CSV download
+	cfg := newConfig(opts)
+	setHeaders(h, cfg.filename(filename, data), cfg)
+	return write(w, data, cfg)
+}
+
+// Write writes data, a slice of structs or pointers to structs, as csv to w
+// using the same struct tags as WriteCSV
+func Write(w io.Writer, data any, opts ...Option) error {
+	return write(w, data, newConfig(opts))
+}
+
+// WriteContext is Write stopping with ctx.Err() once ctx is done
+func WriteContext(
+	ctx context.Context,
+	w io.Writer,
+	data any,
+	opts ...Option,
+) error {
+	cfg := newConfig(opts)
+	cfg.ctx = ctx
+	return write(w, data, cfg)
+}
+
+// setHeaders sets the headers of a CSV download
+func setHeaders(h http.Header, filename string, cfg *config) {
 	h.Set("Content-Type", "text/csv")
-	h.Set(
-		"Content-Disposition",
-		fmt.Sprintf(`attachment; filename="%s"`, filename),
-	)
+	if !cfg.noDisposition {
+		h.Set(
+			"Content-Disposition",
+			fmt.Sprintf(`attachment; filename="%s"`, sanitizeFilename(filename)),
+		)
+	}
+	if cfg.gzip {
+		h.Set("Content-Encoding", "gzip")
+	}
+	if cfg.etag != "" {
+		h.Set("ETag", cfg.etag)
+	}
+	if !cfg.lastModified.IsZero() {
+		h.Set("Last-Modified", cfg.lastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// write writes data as csv records to w and reports the Stats to the
+// observer when one is set
+func write(w io.Writer, data any, cfg *config) error {
+	return observe(w, cfg, func(w io.Writer) (int, error) {
+		return writeRecords(w, data, cfg)
+	})
+}
 
-	writer := csv.NewWriter(w)
-	defer writer.Flush()
+// observe runs fn writing to w, which returns the number of rows written,
+// and reports the Stats to the observer when one is set
+func observe(
+	w io.Writer,
+	cfg *config,
+	fn func(w io.Writer) (int, error),
+) error {
+	if cfg.observer == nil {
+		_, err := fn(w)
+		return cfg.joinRowErrors(err)
+	}
 
+	start := time.Now()
+	counter := &countingWriter{w: w}
+	rows, err := fn(counter)
+	cfg.observer(Stats{
+		RowCount:  rows,
+		ByteCount: counter.n,
+		Duration:  time.Since(start),
+	})
+	return cfg.joinRowErrors(err)
+}
+
+// rowWidth returns the number of cells of the rows writer writes before the
+// WithRowNumbers column is added
+func rowWidth(writer *recordWriter, cfg *config) int {
+	if cfg.rowNumbers {
+		return len(writer.headers) - 1
+	}
+	return len(writer.headers)
+}
+
+// writeRecords writes the header and rows of data to w returning the number
+// of rows written
+func writeRecords(w io.Writer, data any, cfg *config) (rows int, err error) {
+	if value := reflect.ValueOf(data); value.Kind() == reflect.Slice &&
+		value.Len() == 0 {
+		switch cfg.emptyInput {
+		case EmptyInputFile:
+			return 0, writeEmptyFile(w, cfg)
+		case EmptyInputError:
+			return 0, ErrEmptyInput
+		}
+	}
+	writer, err := newRecordWriter(w, cfg)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if flushErr := writer.finish(cfg); err == nil {
+			err = flushErr
+		}
+	}()
+
+	value, elemType, err := sliceType(data, cfg)
+	if err != nil {
+		return 0, err
+	}
+	if err := writePreamble(writer, elemType, cfg); err != nil {
+		return 0, err
+	}
+	if cfg.buffered() {
+		return writeBuffered(writer, value, elemType, cfg)
+	}
+	if err := writeHeader(writer, elemType, cfg); err != nil {
+		return 0, err
+	}
+	return writeRows(writer, value, elemType, cfg)
+}
+
+// sliceType returns the slice value of data and the struct type of its
+// elements, binding the options that depend on it
+func sliceType(data any, cfg *config) (reflect.Value, reflect.Type, error) {
 	value := reflect.ValueOf(data)
 	if value.Kind() != reflect.Slice {
-		return errors.New("data is not a slice")
+		return value, nil, errors.New("data is not a slice")
 	}
 
-	elemType := value.Type().Elem()
-	isPointer := elemType.Kind() == reflect.Ptr
-	if isPointer {
-		elemType = elemType.Elem()
+	elemType, err := elementType(value, cfg)
+	if err != nil {
+		return value, nil, err
 	}
-	if elemType.Kind() != reflect.Struct {
-		return errors.New("slice elements are not structs")
+	if err := cfg.bind(elemType); err != nil {
+		return value, nil, err
 	}
+	lo, hi := cfg.window(value.Len())
+	return value.Slice(lo, hi), elemType, nil
+}
 
-	// Generate headers
-	headers, err := extractHeaders(elemType)
+// writeHeader writes the header record of elemType
+func writeHeader(writer *recordWriter, elemType reflect.Type, cfg *config) error {
+	headers, err := headerRecord(elemType, cfg)
 	if err != nil {
 		return fmt.Errorf("failed to extract headers: %w", err)
 	}
+	var groups, types, units []string
+	if cfg.groupedHeaders {
+		groups = groupRecord(elemType, cfg)
+	}
+	if cfg.typeHeaderRow {
+		types = typeRecord(elemType, cfg)
+	}
+	if cfg.unitsRow {
+		units = unitRecord(elemType, cfg)
+	}
+	return writeHeaders(writer, groups, headers, types, units, cfg)
+}
+
+// writeHeaders writes the header record, preceded by the WithGroupedHeaders
+// group record and followed by the WithTypeHeaderRow and WithUnitsRow
+// records when they are not nil, all led by the WithRowNumbers column,
+// nothing is written under WithSkipHeader
+func writeHeaders(
+	writer *recordWriter,
+	groups, headers, types, units []string,
+	cfg *config,
+) error {
+	if cfg.rowNumbers {
+		headers = append([]string{cfg.rowNumberHeader}, headers...)
+		if groups != nil {
+			groups = append([]string{""}, groups...)
+		}
+	}
+	writer.headers = headers
+	if cfg.skipHeader {
+		return nil
+	}
+	if groups != nil {
+		groups, headers = groupRows(groups, headers, cfg)
+		if err := writer.Write(groups); err != nil {
+			return fmt.Errorf("failed to write header groups: %w", err)
+		}
+	}
 	if err := writer.Write(headers); err != nil {
 		return fmt.Errorf("failed to write headers: %w", err)
 	}
+	if types != nil {
+		if cfg.rowNumbers {
+			types = append([]string{"int"}, types...)
+		}
+		if err := writer.Write(types); err != nil {
+			return fmt.Errorf("failed to write type row: %w", err)
+		}
+	}
+	if units != nil {
+		if cfg.rowNumbers {
+			units = append([]string{""}, units...)
+		}
+		if err := writer.Write(units); err != nil {
+			return fmt.Errorf("failed to write units row: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeRow writes a row record led by its number when WithRowNumbers is set,
+// rows are numbered from 1 in the order written or by their index in the
+// input, the cells WithQuotePredicate picks are quoted
+func writeRow(writer *recordWriter, row record, cfg *config) error {
+	row = prepareRow(row, writer.rows-writer.section, writer.headers, cfg)
+	return writer.writeRow(row)
+}
+
+// prepareRow numbers the row after written rows and quotes the cells
+// WithQuotePredicate picks by headers for writeRow
+func prepareRow(row record, written int, headers []string, cfg *config) record {
+	if cfg.rowNumbers {
+		n := cfg.firstRowNumber() + written
+		if cfg.inputRowNumbers {
+			n = row.index + 1
+		}
+		var numbered record
+		numbered.add(strconv.Itoa(n), false)
+		numbered.extend(row)
+		row = numbered
+	}
+	if cfg.quotePredicate != nil {
+		for i, field := range row.fields {
+			if i < len(headers) && cfg.quotePredicate(headers[i], field) {
+				row.quoted[i] = true
+			}
+		}
+	}
+	return row
+}
 
-	// Write rows
+// writePreamble writes the raw lines before the header, the BOM then the
+// leading comment, elemType is nil when there is no struct type
+func writePreamble(
+	writer *recordWriter,
+	elemType reflect.Type,
+	cfg *config,
+) error {
+	var b strings.Builder
+	if cfg.bom {
+		b.WriteString("\ufeff")
+	}
+	if comment := cfg.leadingComment(elemType); comment != "" {
+		b.WriteString(strings.TrimRight(comment, "\r\n"))
+		b.WriteByte('\n')
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	if err := writer.writeRaw(b.String()); err != nil {
+		return fmt.Errorf("failed to write preamble: %w", err)
+	}
+	return nil
+}
+
+// writeRows writes a record for every element of the slice value returning
+// the number of rows written
+func writeRows(
+	writer *recordWriter,
+	value reflect.Value,
+	elemType reflect.Type,
+	cfg *config,
+) (int, error) {
+	if cfg.parallelism > 1 {
+		return writeRowsParallel(writer, value, elemType, cfg)
+	}
+	rows := 0
 	for i := 0; i < value.Len(); i++ {
-		elem := value.Index(i)
-		if isPointer {
-			elem = elem.Elem()
+		if err := cfg.ctxErr(); err != nil {
+			return rows, err
+		}
+		if !cfg.keepRow(value.Index(i)) {
+			continue
+		}
+		elem, err := element(value, i, elemType, cfg)
+		if err != nil {
+			return rows, err
 		}
 
-		row, err := extractRow(elem, elemType)
+		records, err := rowRecords(elem, elemType, cfg)
 		if err != nil {
-			return fmt.Errorf("failed to extract row %d: %w", i, err)
+			err = fmt.Errorf("failed to extract row %d: %w", i, err)
+			row, ok := cfg.collectRowError(err, rowWidth(writer, cfg))
+			if !ok {
+				return rows, err
+			}
+			records = []record{row}
 		}
 
-		if err := writer.Write(row); err != nil {
-			return fmt.Errorf("failed to write row %d: %w", i, err)
+		for _, row := range records {
+			row.index = cfg.inputIndex(i)
+			if err := writeRow(writer, row, cfg); err != nil {
+				return rows, fmt.Errorf("failed to write row %d: %w", i, err)
+			}
+			rows++
+			if err := periodicFlush(writer, rows, cfg); err != nil {
+				return rows, err
+			}
 		}
 	}
 
+	return rows, nil
+}
+
+// periodicFlush flushes writer through to its io.Writer after every
+// WithFlushEvery rows, rows is the number of rows written so far
+func periodicFlush(writer *recordWriter, rows int, cfg *config) error {
+	if cfg.flushEvery <= 0 || rows%cfg.flushEvery != 0 {
+		return nil
+	}
+	if err := writer.flushAll(); err != nil {
+		return fmt.Errorf("failed to flush: %w", err)
+	}
 	return nil
 }
 
-// extractHeaders generates CSV headers from struct tags
-func extractHeaders(elemType reflect.Type) ([]string, error) {
+// elementType returns the struct type of the elements of the slice value,
+// for a slice of interfaces every element is resolved to its concrete type,
+// or by the WithInterfaceResolver function, and all must resolve to the same
+// struct, values and pointers of it can be mixed
+func elementType(value reflect.Value, cfg *config) (reflect.Type, error) {
+	elemType := value.Type().Elem()
+	if elemType.Kind() == reflect.Interface {
+		if value.Len() == 0 {
+			return nil, errors.New("cannot resolve element type of empty slice")
+		}
+		for i := 0; i < value.Len(); i++ {
+			t, err := cfg.resolveInterface(value.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			if t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			if i == 0 {
+				elemType = t
+			} else if t != elemType {
+				return nil, fmt.Errorf(
+					"element %d is %s, expected %s",
+					i,
+					t,
+					elemType,
+				)
+			}
+		}
+	}
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return nil, errors.New("slice elements are not structs")
+	}
+	return elemType, nil
+}
+
+// element returns element i of the slice value as a value of elemType
+func element(
+	value reflect.Value,
+	i int,
+	elemType reflect.Type,
+	cfg *config,
+) (reflect.Value, error) {
+	elem := value.Index(i)
+	if elem.Kind() == reflect.Interface {
+		elem = elem.Elem()
+	}
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if !elem.IsValid() {
+		return reflect.Value{}, fmt.Errorf("element %d is nil", i)
+	}
+	if elem.Type() != elemType {
+		if !elem.Type().ConvertibleTo(elemType) {
+			return reflect.Value{}, fmt.Errorf(
+				"element %d is %s, expected %s",
+				i,
+				elem.Type(),
+				elemType,
+			)
+		}
+		elem = elem.Convert(elemType)
+	}
+	return elem, nil
+}
+
+// headerRecord generates the header record of elemType, the struct tag
+// headers followed by the columns added by options
+func headerRecord(elemType reflect.Type, cfg *config) ([]string, error) {
+	headers, err := extractHeaders(elemType, "", cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.explodeIndex >= 0 {
+		exploded, err := explodeHeaders(cfg)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, exploded...)
+	}
+	if cfg.catchAllIndex >= 0 {
+		headers = append(headers, cfg.catchAllHeader)
+	}
+	if len(headers) == 0 {
+		return nil, ErrNoColumns
+	}
+	for i, header := range headers {
+		if renamed, ok := cfg.columnRenames[header]; ok {
+			headers[i] = renamed
+		}
+	}
+	if cfg.headers != nil {
+		if len(cfg.headers) != len(headers) {
+			return nil, fmt.Errorf(
+				"got %d headers for %d columns",
+				len(cfg.headers),
+				len(headers),
+			)
+		}
+		headers = cfg.headers
+	}
+	if err := checkRequiredColumns(headers, cfg.requiredColumns); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// checkRequiredColumns returns an error naming the required headers missing
+// from headers
+func checkRequiredColumns(headers, required []string) error {
+	if len(required) == 0 {
+		return nil
+	}
+	present := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		present[header] = true
+	}
+	var missing []string
+	for _, header := range required {
+		if !present[header] {
+			missing = append(missing, header)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required columns %q", missing)
+	}
+	return nil
+}
+
+// rowRecords generates the records of a struct value aligned with
+// headerRecord, one unless WithExplode writes a row per element
+func rowRecords(
+	value reflect.Value,
+	elemType reflect.Type,
+	cfg *config,
+) ([]record, error) {
+	row, err := extractRow(value, cfg.diffBase, elemType, "", cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	rows := []record{row}
+	if cfg.explodeIndex >= 0 {
+		if rows, err = explodeRows(row, value, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.catchAllIndex >= 0 {
+		fieldValue := value.Field(cfg.catchAllIndex)
+		cell, err := formatJSON(fieldValue)
+		if err != nil {
+			return nil, err
+		}
+		unchanged := cfg.diffBase.IsValid() &&
+			isUnchanged(fieldValue, cfg.diffBase.Field(cfg.catchAllIndex))
+		for i := range rows {
+			if unchanged {
+				rows[i].addUnchanged()
+			} else {
+				rows[i].add(cfg.replace(cell), false)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// extractHeaders generates CSV headers from struct tags, prefix is the
+// dotted Go field path of elemType from the slice element and parents the
+// struct types being walked, see expandNested
+func extractHeaders(
+	elemType reflect.Type,
+	prefix string,
+	cfg *config,
+	parents []reflect.Type,
+) ([]string, error) {
+	parents = append(parents, elemType)
+
 	var headers []string
 	for i := 0; i < elemType.NumField(); i++ {
 		field := elemType.Field(i)
-		if isIgnoredField(field) {
+		if cfg.skipField(elemType, i) {
 			continue
 		}
 
-		csvTag := field.Tag.Get("csv")
-		if isSubStruct(field) {
-			subHeaders, err := extractHeaders(field.Type)
+		name := cfg.translateHeader(headerName(field))
+		if err := validateTagOptions(fieldOptions(field)); err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		path := fieldPath(prefix, field.Name)
+		if cfg.expandNested(field, path, parents) {
+			if isEmbeddedStruct(field) {
+				path = prefix
+			}
+			subHeaders, err := extractHeaders(
+				subStructType(field),
+				path,
+				cfg,
+				parents,
+			)
 			if err != nil {
 				return nil, err
 			}
+			if isEmbeddedStruct(field) ||
+				fieldOptions(field).Contains("noprefix") {
+				headers = append(headers, subHeaders...)
+				continue
+			}
 			for _, subHeader := range subHeaders {
 				headers = append(
 					headers,
-					fmt.Sprintf("%s.%s", csvTag, subHeader),
+					name+cfg.headerSeparator+subHeader,
 				)
 			}
+		} else if parts := splitParts(field); parts != nil {
+			for _, part := range parts {
+				headers = append(headers, name+cfg.headerSeparator+part.name)
+			}
 		} else {
-			headers = append(headers, csvTag)
+			headers = append(headers, name)
 		}
 	}
 	return headers, nil
 }
 
-// extractRow generates a CSV row from a struct value
-func extractRow(value reflect.Value, elemType reflect.Type) ([]string, error) {
-	var row []string
+// extractRow generates a CSV row from a struct value, prefix is the dotted
+// Go field path of value from the slice element, base is the matching value
+// of the WithDiffBaseline baseline, invalid when there is none, and parents
+// the struct types being walked
+func extractRow(
+	value reflect.Value,
+	base reflect.Value,
+	elemType reflect.Type,
+	prefix string,
+	cfg *config,
+	parents []reflect.Type,
+) (record, error) {
+	parents = append(parents, elemType)
+
+	var row record
 	for i := 0; i < elemType.NumField(); i++ {
 		field := elemType.Field(i)
-		if isIgnoredField(field) {
+		if cfg.skipField(elemType, i) {
 			continue
 		}
 
-		fieldValue := value.Field(i)
-		if isSubStruct(field) {
-			subRow, err := extractRow(fieldValue, field.Type)
+		var fieldValue, baseValue reflect.Value
+		if value.IsValid() {
+			fieldValue = value.Field(i)
+		}
+		if base.IsValid() {
+			baseValue = base.Field(i)
+		}
+		path := fieldPath(prefix, field.Name)
+		if cfg.expandNested(field, path, parents) {
+			if isEmbeddedStruct(field) {
+				path = prefix
+			}
+			if fieldValue.Kind() == reflect.Ptr {
+				fieldValue = fieldValue.Elem()
+			}
+			if baseValue.Kind() == reflect.Ptr {
+				baseValue = baseValue.Elem()
+			}
+			subRow, err := extractRow(
+				fieldValue,
+				baseValue,
+				subStructType(field),
+				path,
+				cfg,
+				parents,
+			)
 			if err != nil {
-				return nil, err
+				return record{}, err
+			}
+			row.extend(subRow)
+		} else if parts := splitParts(field); parts != nil {
+			splitRow(&row, fieldValue, baseValue, parts, cfg)
+		} else if !fieldValue.IsValid() {
+			// a field of a nil pointer sub-struct
+			if cfg.diffBase.IsValid() && !baseValue.IsValid() {
+				row.addUnchanged()
+			} else {
+				row.add(nullString, false)
 			}
-			row = append(row, subRow...)
+		} else if baseValue.IsValid() && isUnchanged(fieldValue, baseValue) {
+			row.addUnchanged()
+		} else if cfg.expandStruct(field, path) {
+			// a repeated sub-struct, see expandNested
+			row.add(nullString, false)
 		} else {
-			row = append(row, formatValue(fieldValue))
+			cell, err := formatField(fieldValue, field, path, cfg)
+			if err != nil {
+				cell, err = cfg.cellError(err)
+				if err != nil {
+					return record{}, fmt.Errorf("field %s: %w", field.Name, err)
+				}
+			}
+			cell = cfg.replace(cell)
+			row.add(cell, isQuotedCell(cell, fieldValue, field, cfg))
 		}
 	}
 	return row, nil
 }
 
-// isIgnoredField Helper to check if a field should be ignored
-func isIgnoredField(field reflect.StructField) bool {
-	return field.Tag.Get("csv") == "-"
+// isUnchanged reports whether a field value equals its baseline value
+func isUnchanged(value, base reflect.Value) bool {
+	return reflect.DeepEqual(value.Interface(), base.Interface())
+}
+
+// fieldPath joins a nested field name to the dotted path of its parent
+func fieldPath(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// isIgnoredField Helper to check if a field should be ignored, only the
+// exact tag ignoreValue, "-" by default, ignores a field while "-," names a
+// column "-", an empty ignoreValue ignores no tag, unexported
+// fields are ignored too even inside a nested struct of an unexported type,
+// except embedded structs of unexported types whose exported fields are
+// flattened like encoding/json promotes them, and func fields
+func isIgnoredField(field reflect.StructField, ignoreValue string) bool {
+	return ignoreValue != "" && field.Tag.Get("csv") == ignoreValue ||
+		!field.IsExported() && !isPromotedStruct(field) ||
+		field.Type.Kind() == reflect.Func
 }
 
-// isSubStruct Helper to check if a field is a sub-struct (non-time struct)
+// isPromotedStruct reports whether field is an embedded struct, or pointer
+// to one, without a csv name whose fields are flattened into the parent
+func isPromotedStruct(field reflect.StructField) bool {
+	return isEmbeddedStruct(field) &&
+		isSubStructType(subStructType(field)) &&
+		!isInlineJSON(field)
+}
+
+// expandNested reports whether the field at path is expanded into columns
+// by expandStruct and is not a pointer back to a struct type in parents,
+// like a Parent *T field of T, which is written as one blank cell instead
+// of columns that never end
+func (c *config) expandNested(
+	field reflect.StructField,
+	path string,
+	parents []reflect.Type,
+) bool {
+	return c.expandStruct(field, path) &&
+		!slices.Contains(parents, subStructType(field))
+}
+
+// isSubStruct Helper to check if a field is a sub-struct (non-time,
+// non-Marshaler struct not tagged inline=json), embedded or not
 func isSubStruct(field reflect.StructField) bool {
-	return field.Type.Kind() == reflect.Struct &&
-		field.Type != reflect.TypeOf(time.Time{}) &&
-		!field.Anonymous
+	return isSubStructType(field.Type) && !isInlineJSON(field)
+}
+
+// isSubStructType Helper to check if a type is a non-time, non-Marshaler
+// struct, a struct with a String or MarshalText method like a decimal type
+// is a single column too
+func isSubStructType(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct &&
+		!isTimeType(t) &&
+		!implementsMarshaler(t) &&
+		!isTextStruct(t)
 }
 
-// formatValue formats a field value into a string for CSV
-func formatValue(value reflect.Value) string {
-	if value.Kind() == reflect.Ptr {
+// subStructType returns the struct type of a sub-struct field, the element
+// type of a pointer sub-struct
+func subStructType(field reflect.StructField) reflect.Type {
+	if field.Type.Kind() == reflect.Ptr {
+		return field.Type.Elem()
+	}
+	return field.Type
+}
+
+// isEmbeddedStruct Helper to check if a sub-struct field is embedded without
+// a csv name, its columns are flattened into the parent with no prefix, a
+// named embedded struct is prefixed like any sub-struct
+func isEmbeddedStruct(field reflect.StructField) bool {
+	return field.Anonymous && headerName(field) == ""
+}
+
+// ErrNoColumns is returned for structs whose fields are all ignored or
+// unexported
+var ErrNoColumns = errors.New("struct has no exportable csv columns")
+
+// ErrEmptyInput is returned for an empty slice under EmptyInputError
+var ErrEmptyInput = errors.New("no rows to write")
+
+// nullString is the cell written for nil pointers and invalid values
+const nullString = ""
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// isTimeType reports whether t is time.Time or a defined type over it like
+// type Timestamp time.Time, which is written as a time and not a sub-struct
+func isTimeType(t reflect.Type) bool {
+	return t == timeType ||
+		(t.Kind() == reflect.Struct && t.ConvertibleTo(timeType))
+}
+
+// formatField formats the value of the field at path applying its tag
+// options and the options configured for that field before the type based
+// formatting of formatValue
+func formatField(
+	value reflect.Value,
+	field reflect.StructField,
+	path string,
+	cfg *config,
+) (string, error) {
+	cell, err := formatFieldValue(value, field, path, cfg)
+	if err != nil {
+		return "", err
+	}
+	opts := fieldOptions(field)
+	cell, err = padCell(cell, opts, cfg)
+	if err != nil {
+		return "", err
+	}
+	return excelText(cell, opts), nil
+}
+
+// formatFieldValue formats the value of the field at path before the tag
+// options that apply to the formatted cell
+func formatFieldValue(
+	value reflect.Value,
+	field reflect.StructField,
+	path string,
+	cfg *config,
+) (string, error) {
+	if fn, ok := cfg.fieldFormatters[path]; ok {
+		return fn(cfg.context(), value)
+	}
+	if cfg.isJSONField(field, path) {
+		return formatJSON(value)
+	}
+	if field.Anonymous && field.Type.Kind() == reflect.Interface {
+		return formatEmbeddedInterface(value, cfg)
+	}
+	if as, _ := fieldOptions(field).Lookup("as"); as == "char" {
+		if cell, ok, err := formatChar(value); ok {
+			return cell, err
+		}
+	}
+	if scale, ok := fieldOptions(field).Lookup("scale"); ok {
+		n, _ := strconv.Atoi(scale)
+		if cell, ok := formatScaled(value, n, cfg); ok {
+			return cell, nil
+		}
+	}
+	if mode, ok := fieldOptions(field).Lookup("bytes"); ok {
+		if cell, ok, err := formatBytes(value, mode); ok {
+			return cell, err
+		}
+	}
+	if fieldOptions(field).Contains("invert") {
+		if cell, ok := formatInverted(value, cfg); ok {
+			return cell, nil
+		}
+	}
+	if labels, ok := cfg.enumLabels[path]; ok {
+		if cell, ok := enumLabel(value, labels); ok {
+			return cell, nil
+		}
+	}
+	if mask, ok := cfg.bitmasks[path]; ok {
+		if cell, ok := bitmaskLabels(value, mask, cfg); ok {
+			return cell, nil
+		}
+	}
+	return formatValue(value, cfg)
+}
+
+// formatBytes formats a []byte value in the bytes tag option mode, "string"
+// for its content as is, "hex" or "base64", ok is false when value is not a
+// []byte
+func formatBytes(value reflect.Value, mode string) (string, bool, error) {
+	value, ok := indirect(value)
+	if !ok {
+		return nullString, true, nil
+	}
+	if !isBytes(value.Type()) {
+		return "", false, nil
+	}
+	if value.IsNil() {
+		return nullString, true, nil
+	}
+	switch mode {
+	case "string":
+		return string(value.Bytes()), true, nil
+	case "hex":
+		return hex.EncodeToString(value.Bytes()), true, nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString(value.Bytes()), true, nil
+	}
+	return "", true, fmt.Errorf("unknown bytes mode %q", mode)
+}
+
+// isBytes reports whether t is a byte slice
+func isBytes(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+}
+
+// formatInverted formats the negation of a bool value, ok is false when
+// value is not a bool
+func formatInverted(value reflect.Value, cfg *config) (string, bool) {
+	value, ok := indirect(value)
+	if !ok {
+		return nullString, true
+	}
+	if value.Kind() != reflect.Bool {
+		return "", false
+	}
+	return cfg.formatBool(!value.Bool()), true
+}
+
+// formatEmbeddedInterface formats the value of an embedded interface field
+// as one column, the concrete value is formatted like a field unless it has
+// no WithTypeFormatter formatter or MarshalCSV but a String method
+func formatEmbeddedInterface(value reflect.Value, cfg *config) (string, error) {
+	if value.IsNil() {
+		return nullString, nil
+	}
+	elem := value.Elem()
+	if elem.Kind() == reflect.Ptr && elem.IsNil() {
+		return nullString, nil
+	}
+	if cfg.hasTypeFormatter(elem.Type()) || implementsMarshaler(elem.Type()) {
+		return formatValue(elem, cfg)
+	}
+	if stringer, ok := implementer(elem, stringerType); ok {
+		return stringer.Interface().(fmt.Stringer).String(), nil
+	}
+	return formatValue(elem, cfg)
+}
+
+// enumLabel looks up the label of an integer value, ok is false when value
+// is not an integer or has no label
+func enumLabel(value reflect.Value, labels map[int64]string) (string, bool) {
+	value, ok := indirect(value)
+	if !ok {
+		return "", false
+	}
+	var n int64
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = int64(value.Uint())
+	default:
+		return "", false
+	}
+	label, ok := labels[n]
+	return label, ok
+}
+
+// formatChar formats an integer value such as a rune or byte as the
+// character of its code point, ok is false when value is not an integer
+func formatChar(value reflect.Value) (cell string, ok bool, err error) {
+	value, ok = indirect(value)
+	if !ok {
+		return nullString, true, nil
+	}
+	var n int64
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if value.Uint() > utf8.MaxRune {
+			return "", true, fmt.Errorf("invalid code point %d", value.Uint())
+		}
+		n = int64(value.Uint())
+	default:
+		return "", false, nil
+	}
+	if n < 0 || n > utf8.MaxRune || !utf8.ValidRune(rune(n)) {
+		return "", true, fmt.Errorf("invalid code point %d", n)
+	}
+	return string(rune(n)), true, nil
+}
+
+// indirect follows value through any level of pointers and interfaces, ok
+// is false when a pointer or interface along the chain is nil
+func indirect(value reflect.Value) (reflect.Value, bool) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
 		if value.IsNil() {
-			return ""
+			return value, false
 		}
 		value = value.Elem()
 	}
+	return value, true
+}
+
+// formatValue formats a field value into a string for CSV, a non-numeric
+// value with a MarshalText or String method is written with it, kinds
+// without a csv representation return an error wrapping ErrUnsupportedType
+func formatValue(value reflect.Value, cfg *config) (string, error) {
+	if !value.IsValid() {
+		return nullString, nil
+	}
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nullString, nil
+		}
+		if cell, ok, err := cfg.formatType(value); ok {
+			return cell, err
+		}
+		if cell, ok, err := marshalCell(value); ok {
+			return cell, err
+		}
+		value = value.Elem()
+	}
+	if cell, ok, err := cfg.formatType(value); ok {
+		return cell, err
+	}
+	if cell, ok, err := marshalCell(value); ok {
+		return cell, err
+	}
+	if cell, ok, err := formatText(value, cfg); ok {
+		return cell, err
+	}
 	switch value.Kind() {
 	case reflect.String:
-		return value.String()
+		s := value.String()
+		if cfg.stripValueBOM {
+			s = strings.TrimPrefix(s, "\ufeff")
+		}
+		if cfg.stripReplacementChar {
+			s = strings.ReplaceAll(s, string(utf8.RuneError), "")
+		}
+		if cfg.trimStrings {
+			s = strings.TrimSpace(s)
+		}
+		return s, nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return strconv.FormatInt(value.Int(), 10)
+		if cfg.blankZeroNumbers && value.Int() == 0 {
+			return nullString, nil
+		}
+		return cfg.formatNumber(strconv.FormatInt(value.Int(), 10)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Uintptr:
+		if cfg.blankZeroNumbers && value.Uint() == 0 {
+			return nullString, nil
+		}
+		return cfg.formatNumber(strconv.FormatUint(value.Uint(), 10)), nil
 	case reflect.Float32, reflect.Float64:
-		return strconv.FormatFloat(value.Float(), 'f', -1, 64)
+		if cfg.blankZeroNumbers && value.Float() == 0 {
+			return nullString, nil
+		}
+		cell := cfg.formatFloat(value.Float(), value.Type().Bits())
+		return cfg.formatNumber(cell), nil
 	case reflect.Bool:
-		return strconv.FormatBool(value.Bool())
+		return cfg.formatBool(value.Bool()), nil
+	case reflect.Map:
+		if value.IsNil() {
+			return nullString, nil
+		}
+		return formatMap(value, cfg)
+	case reflect.Slice:
+		if value.IsNil() {
+			return nullString, nil
+		}
+		if isBytes(value.Type()) {
+			return base64.StdEncoding.EncodeToString(value.Bytes()), nil
+		}
+		return formatSlice(value, cfg)
+	case reflect.Array:
+		return formatSlice(value, cfg)
+	case reflect.UnsafePointer:
+		// never dereferenced, it has no csv representation
 	case reflect.Struct:
-		if value.Type() == reflect.TypeOf(time.Time{}) {
-			return value.Interface().(time.Time).Format("2006-01-02 15:04")
+		if isTimeType(value.Type()) {
+			t := value.Convert(timeType).Interface().(time.Time)
+			return cfg.formatTime(t), nil
 		}
-		return ""
-	default:
-		return ""
 	}
+	return "", fmt.Errorf("%w: %s", ErrUnsupportedType, value.Type())
+}
+
+// isSupportedType reports whether formatValue has a csv representation for
+// values of t
+func isSupportedType(t reflect.Type, cfg *config) bool {
+	for t.Kind() == reflect.Ptr {
+		if cfg.hasTypeFormatter(t) || implementsMarshaler(t) {
+			return true
+		}
+		t = t.Elem()
+	}
+	if cfg.hasTypeFormatter(t) || implementsMarshaler(t) || cfg.isTextType(t) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		// checked against the dynamic value by formatValue
+		return true
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Uintptr, reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Map:
+		return isSupportedType(t.Key(), cfg) &&
+			(isSupportedType(t.Elem(), cfg) || cfg.isJSONMapValue(t.Elem()))
+	case reflect.Slice, reflect.Array:
+		return isSupportedType(t.Elem(), cfg)
+	case reflect.Struct:
+		return isTimeType(t)
+	}
+	return false
+}
+
+// formatMap formats a map as key=value pairs sorted by key and joined by the
+// map separator, keys and values are formatted like fields
+func formatMap(value reflect.Value, cfg *config) (string, error) {
+	pairs := make([][2]string, 0, value.Len())
+	iter := value.MapRange()
+	for iter.Next() {
+		k, err := formatValue(iter.Key(), cfg)
+		if err != nil {
+			return "", err
+		}
+		var v string
+		if cfg.isJSONMapValue(iter.Value().Type()) {
+			v, err = formatJSON(iter.Value())
+		} else {
+			v, err = formatValue(iter.Value(), cfg)
+		}
+		if err != nil {
+			return "", fmt.Errorf("key %s: %w", k, err)
+		}
+		pairs = append(pairs, [2]string{k, v})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i][0] < pairs[j][0]
+	})
+
+	var b strings.Builder
+	for i, pair := range pairs {
+		if i > 0 {
+			b.WriteString(cfg.mapSeparator)
+		}
+		b.WriteString(pair[0])
+		b.WriteByte('=')
+		b.WriteString(pair[1])
+	}
+	return b.String(), nil
+}
+
+// formatSlice formats the elements of a slice or array like fields joined by
+// the slice separator, a nil element is written as nullString
+func formatSlice(value reflect.Value, cfg *config) (string, error) {
+	var b strings.Builder
+	for i := 0; i < value.Len(); i++ {
+		cell, err := formatValue(value.Index(i), cfg)
+		if err != nil {
+			return "", fmt.Errorf("index %d: %w", i, err)
+		}
+		if i > 0 {
+			b.WriteString(cfg.sliceSeparator)
+		}
+		b.WriteString(cell)
+	}
+	return b.String(), nil
+}
+
+// isQuotedCell reports whether cell must be quoted, an empty string under
+// WithQuoteEmptyStrings or a number or bool of a field with the string option
+func isQuotedCell(
+	cell string,
+	value reflect.Value,
+	field reflect.StructField,
+	cfg *config,
+) bool {
+	if cell == "" {
+		return cfg.quoteEmptyStrings && isString(value)
+	}
+	return fieldOptions(field).Contains("string") && isNumberOrBool(value)
+}
+
+// isNumberOrBool reports whether value is a number or bool or a non-nil
+// pointer to one
+func isNumberOrBool(value reflect.Value) bool {
+	value, ok := indirect(value)
+	if !ok {
+		return false
+	}
+	switch value.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// isString reports whether value is a string or a non-nil pointer to one
+func isString(value reflect.Value) bool {
+	value, ok := indirect(value)
+	return ok && value.Kind() == reflect.String
+}
+
+// formatJSON formats a field value as a compact JSON cell, nil maps and
+// interfaces are written as nullString
+func formatJSON(value reflect.Value) (string, error) {
+	switch value.Kind() {
+	case reflect.Map, reflect.Interface, reflect.Ptr, reflect.Slice:
+		if value.IsNil() {
+			return nullString, nil
+		}
+	}
+	if !value.CanInterface() {
+		return "", fmt.Errorf("failed to marshal json cell: unexported %s", value.Type())
+	}
+	b, err := json.Marshal(value.Interface())
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal json cell: %w", err)
+	}
+	return string(b), nil
 }
@@ -0,0 +1,2253 @@
+package struct2csv
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// writeString writes data with opts and returns the csv, failing t on error
+func writeString(t *testing.T, data any, opts ...Option) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := Write(&b, data, opts...); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return b.String()
+}
+
+type invalidRow struct {
+	Name  string   `csv:"name"`
+	Value **string `csv:"value"`
+}
+
+func TestFormatValueInvalid(t *testing.T) {
+	cell, err := formatValue(reflect.Value{}, newConfig(nil))
+	if err != nil || cell != nullString {
+		t.Errorf("got %q, %v, want %q, nil", cell, err, nullString)
+	}
+
+	var nilString *string
+	got := writeString(t, []invalidRow{{Name: "a"}, {Name: "b", Value: &nilString}})
+	want := "name,value\na,\nb,\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type catchAllEvent struct {
+	Name  string         `csv:"name"`
+	Extra map[string]any `csv:"-"`
+	Age   int            `csv:"age"`
+}
+
+func TestCatchAllIsLastJSONColumn(t *testing.T) {
+	events := []catchAllEvent{
+		{Name: "a", Extra: map[string]any{"k": 1, "b": "x"}, Age: 3},
+		{Name: "b"},
+	}
+	got := writeString(t, events, WithCatchAll("extra", "Extra"))
+	want := "name,age,extra\n" +
+		`a,3,"{""b"":""x"",""k"":1}"` + "\n" +
+		"b,0,\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type dashRow struct {
+	Ignored string `csv:"-"`
+	Dash    string `csv:"-,"`
+	Name    string `csv:"name"`
+}
+
+func TestDashTag(t *testing.T) {
+	got := writeString(t, []dashRow{{Ignored: "i", Dash: "d", Name: "n"}})
+	want := "-,name\nd,n\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type groupedRow struct {
+	Count  int     `csv:"count"`
+	Amount float64 `csv:"amount"`
+}
+
+func TestNumberGrouping(t *testing.T) {
+	rows := []groupedRow{{Count: 1234567, Amount: -1234567.89}}
+	if got, want := writeString(t, rows), "count,amount\n1234567,-1234567.89\n"; got != want {
+		t.Errorf("default: got %q, want %q", got, want)
+	}
+
+	got := writeString(t, rows, WithNumberGrouping(true))
+	records, err := csv.NewReader(strings.NewReader(got)).ReadAll()
+	if err != nil {
+		t.Fatalf("parse %q: %v", got, err)
+	}
+	want := []string{"1,234,567", "-1,234,567.89"}
+	if len(records) != 2 || !slices.Equal(records[1], want) {
+		t.Errorf("got %q, want row %q", records, want)
+	}
+
+	got = writeString(t, rows, WithNumberGrouping(true), WithLocale(LocaleArabic))
+	if want := "count,amount\n١٬٢٣٤٬٥٦٧,-١٬٢٣٤٬٥٦٧٫٨٩\n"; got != want {
+		t.Errorf("arabic: got %q, want %q", got, want)
+	}
+}
+
+type cachedRow struct {
+	Name string `csv:"name"`
+}
+
+func TestWriteCSVRequestNotModified(t *testing.T) {
+	rows := []cachedRow{{Name: "a"}}
+
+	r := httptest.NewRequest(http.MethodGet, "/export", nil)
+	r.Header.Set("If-None-Match", `"v1"`)
+	rec := httptest.NewRecorder()
+	if err := WriteCSVRequest(rec, r, "export.csv", rows, WithETag("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("got %d with %q, want 304 without a body", rec.Code, rec.Body)
+	}
+
+	r.Header.Set("If-None-Match", `"v0"`)
+	rec = httptest.NewRecorder()
+	if err := WriteCSVRequest(rec, r, "export.csv", rows, WithETag("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != "name\na\n" {
+		t.Errorf("got %d with %q, want 200 with the csv", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("ETag"); got != `"v1"` {
+		t.Errorf("got ETag %q, want %q", got, `"v1"`)
+	}
+}
+
+type failingCell struct{}
+
+func (failingCell) MarshalCSV() (string, error) {
+	return "", errors.New("boom")
+}
+
+type errorCellRow struct {
+	Value failingCell `csv:"value"`
+	N     int         `csv:"n"`
+}
+
+func TestErrorCellString(t *testing.T) {
+	rows := []errorCellRow{{N: 1}}
+	got := writeString(t, rows, WithErrorCellString("#ERR"))
+	if want := "value,n\n#ERR,1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	rec := httptest.NewRecorder()
+	err := WriteCSV(rec.Header(), rec, "export.csv", rows, WithErrorCellString("#ERR"), WithStrict(true))
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("strict: got %v, want the marshaler error", err)
+	}
+}
+
+type quotedRow struct {
+	Text string `csv:"text"`
+	N    int    `csv:"n"`
+}
+
+func TestWithQuote(t *testing.T) {
+	rows := []quotedRow{{Text: "it's, here", N: 1}, {Text: "plain", N: 2}}
+	got := writeString(t, rows, WithQuote('\''))
+	want := "text,n\n'it''s, here',1\nplain,2\n"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// swapping the quote characters makes it readable by encoding/csv
+	swap := strings.NewReplacer("'", `"`, `"`, "'")
+	records, err := csv.NewReader(strings.NewReader(swap.Replace(got))).ReadAll()
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if text := swap.Replace(records[1][0]); text != rows[0].Text {
+		t.Errorf("got %q back, want %q", text, rows[0].Text)
+	}
+}
+
+type trimRow struct {
+	Name  string  `csv:"name"`
+	Note  *string `csv:"note"`
+	Count int     `csv:"count"`
+}
+
+func TestTrimStrings(t *testing.T) {
+	note := "\tnote "
+	rows := []trimRow{{Name: "  a  ", Note: &note, Count: 1}, {Name: "b"}}
+	got := writeString(t, rows, WithTrimStrings(true))
+	if want := "name,note,count\na,note,1\nb,,0\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got = writeString(t, rows)
+	if want := "name,note,count\n\"  a  \",\"\tnote \",1\nb,,0\n"; got != want {
+		t.Errorf("default: got %q, want %q", got, want)
+	}
+}
+
+type surveyRow struct {
+	Answer *bool `csv:"answer"`
+}
+
+func TestBoolPointerStates(t *testing.T) {
+	yes, no := true, false
+	rows := []surveyRow{{Answer: &yes}, {Answer: &no}, {}}
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"default", nil, "answer\ntrue\nfalse\n\n"},
+		{"custom", []Option{WithBoolStrings("Yes", "No")}, "answer\nYes\nNo\n\n"},
+	}
+	for _, tt := range tests {
+		if got := writeString(t, rows, tt.opts...); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+type filenameOrder struct {
+	Month string `csv:"month"`
+}
+
+func TestFilenameFunc(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rows := []filenameOrder{{Month: "2024-06"}}
+	err := WriteCSV(rec.Header(), rec, "orders.csv", rows,
+		WithFilenameFunc(func(data any) string {
+			return "orders_" + data.([]filenameOrder)[0].Month + ".csv"
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := rec.Header().Get("Content-Disposition")
+	if want := `attachment; filename="orders_2024-06.csv"`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type hiddenProfile struct {
+	City   string `csv:"city"`
+	secret string
+}
+
+type hiddenUser struct {
+	Name    string        `csv:"name"`
+	Profile hiddenProfile `csv:"profile"`
+}
+
+func TestUnexportedNestedType(t *testing.T) {
+	rows := []hiddenUser{{Name: "a", Profile: hiddenProfile{City: "طرابلس", secret: "s"}}}
+	got := writeString(t, rows)
+	if want := "name,profile.city\na,طرابلس\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type validModel struct {
+	Name string `csv:"name"`
+	Tags []int  `csv:"tags"`
+}
+
+type duplicateModel struct {
+	First  string `csv:"name"`
+	Second string `csv:"name"`
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(reflect.TypeOf(&validModel{})); err != nil {
+		t.Errorf("valid: %v", err)
+	}
+	err := Validate(reflect.TypeOf(duplicateModel{}))
+	if err == nil || !strings.Contains(err.Error(), `duplicate header "name"`) {
+		t.Errorf("duplicate: got %v", err)
+	}
+}
+
+type enumLabelRow struct {
+	Status int  `csv:"status"`
+	Prev   *int `csv:"prev"`
+}
+
+func TestEnumLabels(t *testing.T) {
+	labels := map[int64]string{1: "active", 2: "closed"}
+	prev := 2
+	rows := []enumLabelRow{{Status: 1, Prev: &prev}, {Status: 7}}
+	got := writeString(t, rows,
+		WithEnumLabels("Status", labels),
+		WithEnumLabels("Prev", labels),
+	)
+	if want := "status,prev\nactive,closed\n7,\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type observedRow struct {
+	N int `csv:"n"`
+}
+
+func TestObserverRowCount(t *testing.T) {
+	rows := make([]observedRow, 25)
+	var stats Stats
+	calls := 0
+	var b bytes.Buffer
+	err := Write(&b, rows, WithObserver(func(s Stats) {
+		stats = s
+		calls++
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || stats.RowCount != len(rows) {
+		t.Errorf("got %d calls with %+v, want 1 with %d rows", calls, stats, len(rows))
+	}
+	if stats.ByteCount != int64(b.Len()) {
+		t.Errorf("got %d bytes, want %d", stats.ByteCount, b.Len())
+	}
+}
+
+type inlineUser struct {
+	Name string `csv:"name"`
+	Age  int    `csv:"age"`
+}
+
+type inlineRow struct {
+	Exploded inlineUser `csv:"a"`
+	Inlined  inlineUser `csv:"b,inline=json"`
+}
+
+func TestInlineJSON(t *testing.T) {
+	rows := []inlineRow{{inlineUser{"x", 1}, inlineUser{"y", 2}}}
+	got := writeString(t, rows)
+	want := "a.name,a.age,b\n" + `x,1,"{""Name"":""y"",""Age"":2}"` + "\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type shape interface {
+	Area() float64
+}
+
+type square struct {
+	Side float64 `csv:"side"`
+}
+
+func (s square) Area() float64 { return s.Side * s.Side }
+
+type circle struct {
+	R float64 `csv:"r"`
+}
+
+func (c circle) Area() float64 { return 3 * c.R * c.R }
+
+func TestInterfaceResolver(t *testing.T) {
+	resolve := WithInterfaceResolver(func(elem reflect.Value) reflect.Type {
+		return elem.Elem().Type()
+	})
+	got := writeString(t, []shape{square{1}, square{2.5}}, resolve)
+	if want := "side\n1\n2.5\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	err := Write(&bytes.Buffer{}, []shape{square{1}, circle{2}}, resolve)
+	if err == nil || !strings.Contains(err.Error(), "element 1") {
+		t.Errorf("mismatch: got %v", err)
+	}
+}
+
+type emptyStringRow struct {
+	Nil   *string `csv:"nil"`
+	Empty *string `csv:"empty"`
+	Value string  `csv:"value"`
+	N     int     `csv:"n"`
+}
+
+func TestQuoteEmptyStrings(t *testing.T) {
+	empty := ""
+	rows := []emptyStringRow{{Empty: &empty}}
+	if got, want := writeString(t, rows), "nil,empty,value,n\n,,,0\n"; got != want {
+		t.Errorf("default: got %q, want %q", got, want)
+	}
+	got := writeString(t, rows, WithQuoteEmptyStrings(true))
+	if want := "nil,empty,value,n\n,\"\",\"\",0\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type charRow struct {
+	Initial rune `csv:"initial,as=char"`
+	Code    rune `csv:"code"`
+	Grade   byte `csv:"grade,as=char"`
+}
+
+func TestCharOption(t *testing.T) {
+	rows := []charRow{{Initial: 'م', Code: 'م', Grade: 'A'}}
+	if got, want := writeString(t, rows), "initial,code,grade\nم,1605,A\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	err := Write(&bytes.Buffer{}, []charRow{{Initial: -1}}, WithStrict(true))
+	if err == nil || !strings.Contains(err.Error(), "invalid code point") {
+		t.Errorf("invalid rune: got %v", err)
+	}
+}
+
+func TestWithoutDisposition(t *testing.T) {
+	rec := httptest.NewRecorder()
+	err := WriteCSV(rec.Header(), rec, "x.csv", []cachedRow{{"a"}}, WithoutDisposition())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rec.Header()["Content-Disposition"]; ok {
+		t.Errorf("got Content-Disposition %q", rec.Header().Get("Content-Disposition"))
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/csv" {
+		t.Errorf("got Content-Type %q", got)
+	}
+}
+
+type padRow struct {
+	Left  string `csv:"left,pad=5"`
+	Right int    `csv:"right,pad=4,align=right"`
+}
+
+func TestPad(t *testing.T) {
+	got := writeString(t, []padRow{{Left: "ab", Right: 12}})
+	if want := "left,right\nab   ,\"  12\"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	long := []padRow{{Left: "abcdefg", Right: 1}}
+	err := Write(&bytes.Buffer{}, long, WithStrict(true))
+	if err == nil || !strings.Contains(err.Error(), "longer than pad width 5") {
+		t.Errorf("error mode: got %v, want a pad width error", err)
+	}
+	got = writeString(t, long, WithPadOverflow(PadOverflowTruncate))
+	if want := "left,right\nabcde,\"   1\"\n"; got != want {
+		t.Errorf("truncate mode: got %q, want %q", got, want)
+	}
+}
+
+type sectionUser struct {
+	Name string `csv:"name"`
+}
+
+type sectionOrder struct {
+	ID    int     `csv:"id"`
+	Total float64 `csv:"total"`
+}
+
+func TestEncoderWriteSection(t *testing.T) {
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	if err := enc.WriteSection([]sectionUser{{"a"}, {"b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteSection([]sectionOrder{{1, 9.5}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	sections := strings.Split(b.String(), "\n\n")
+	if len(sections) != 2 {
+		t.Fatalf("got %d sections in %q, want 2", len(sections), b.String())
+	}
+	want := [][][]string{
+		{{"name"}, {"a"}, {"b"}},
+		{{"id", "total"}, {"1", "9.5"}},
+	}
+	for i, section := range sections {
+		records, err := csv.NewReader(strings.NewReader(section)).ReadAll()
+		if err != nil {
+			t.Fatalf("section %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(records, want[i]) {
+			t.Errorf("section %d: got %q, want %q", i, records, want[i])
+		}
+	}
+}
+
+// countingCell calls count every time it is marshaled
+type countingCell struct {
+	count func()
+}
+
+func (c countingCell) MarshalCSV() (string, error) {
+	c.count()
+	return "n", nil
+}
+
+type countingRow struct {
+	Cell countingCell `csv:"cell"`
+}
+
+func TestParallelCancelNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var formatted atomic.Int64
+	rows := make([]countingRow, 100000)
+	for i := range rows {
+		rows[i].Cell.count = func() {
+			if formatted.Add(1) == 100 {
+				cancel()
+			}
+		}
+	}
+	err := WriteContext(ctx, io.Discard, rows, WithParallelism(8))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if n := formatted.Load(); n >= int64(len(rows)) {
+		t.Errorf("formatted all %d rows after cancel", n)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("got %d goroutines after the write, want %d", after, before)
+	}
+}
+
+type doublePointerRow struct {
+	Name **string `csv:"name"`
+	N    **int    `csv:"n"`
+}
+
+func TestDoublePointers(t *testing.T) {
+	name, n := "a", 3
+	namePtr, nPtr := &name, &n
+	var nilName *string
+	rows := []doublePointerRow{
+		{Name: &namePtr, N: &nPtr},
+		{},
+		{Name: &nilName},
+	}
+	if got, want := writeString(t, rows), "name,n\na,3\n,\n,\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// flushRecorder records the number of bytes written at every Flush call
+type flushRecorder struct {
+	bytes.Buffer
+	flushes []int
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushes = append(f.flushes, f.Len())
+}
+
+func TestFlushEvery(t *testing.T) {
+	rows := make([]observedRow, 5)
+
+	var w flushRecorder
+	if err := Write(&w, rows, WithFlushEvery(2)); err != nil {
+		t.Fatal(err)
+	}
+	// the header and each row are 2 bytes
+	if want := []int{6, 10, 12}; !slices.Equal(w.flushes, want) {
+		t.Errorf("got flushes at %v, want %v", w.flushes, want)
+	}
+
+	w = flushRecorder{}
+	if err := Write(&w, rows, WithFlushEvery(2), WithAutoFlush(false)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{6, 10}; !slices.Equal(w.flushes, want) || w.Len() != 12 {
+		t.Errorf("got flushes at %v of %d bytes, want %v of 12", w.flushes, w.Len(), want)
+	}
+}
+
+type profileUser struct {
+	Name   string  `csv:"name"`
+	Email  string  `csv:"email" csv_profiles:"admin,full"`
+	Salary float64 `csv:"salary" csv_profiles:"full"`
+}
+
+func TestProfiles(t *testing.T) {
+	rows := []profileUser{{Name: "a", Email: "a@x", Salary: 10}}
+	tests := []struct {
+		profile, want string
+	}{
+		{"admin", "name,email\na,a@x\n"},
+		{"full", "name,email,salary\na,a@x,10\n"},
+		{"public", "name\na\n"},
+	}
+	for _, tt := range tests {
+		if got := writeString(t, rows, WithProfile(tt.profile)); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.profile, got, tt.want)
+		}
+	}
+}
+
+// fakeDriver serves the columns and rows of fakeResult to any query
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("no tx") }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("no exec")
+}
+
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &fakeRows{rows: fakeResult}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+var fakeResult = [][]driver.Value{
+	{int64(1), 2.5, []byte("أحمد"), time.Date(2024, 6, 12, 9, 30, 0, 0, time.UTC)},
+	{int64(2), nil, nil, nil},
+}
+
+func (r *fakeRows) Columns() []string { return []string{"id", "score", "name", "at"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func init() {
+	sql.Register("struct2csv-fake", fakeDriver{})
+}
+
+func TestWriteSQLRows(t *testing.T) {
+	db, err := sql.Open("struct2csv-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("select")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var b bytes.Buffer
+	if err := WriteSQLRows(&b, rows); err != nil {
+		t.Fatal(err)
+	}
+	want := "id,score,name,at\n1,2.5,أحمد,2024-06-12 09:30\n2,,,\n"
+	if got := b.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type anyRow struct {
+	Value any `csv:"value"`
+	N     int `csv:"n"`
+}
+
+func TestNilInterfaces(t *testing.T) {
+	var x any = (*string)(nil)
+	var y any
+	s := "a"
+	rows := []anyRow{{Value: x}, {Value: y}, {Value: &s}}
+	if got, want := writeString(t, rows), "value,n\n,0\n,0\na,0\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type auditRow struct {
+	Name   string `csv:"name"`
+	Status string `csv:"status"`
+	Amount int    `csv:"amount"`
+}
+
+func TestDiffBaseline(t *testing.T) {
+	base := auditRow{Name: "a", Status: "open", Amount: 10}
+	rows := []auditRow{
+		{Name: "a", Status: "closed", Amount: 10},
+		{Name: "a", Status: "open", Amount: 12},
+	}
+	got := writeString(t, rows, WithDiffBaseline(base, DiffBlank))
+	if want := "name,status,amount\n,closed,\n,,12\n"; got != want {
+		t.Errorf("blank: got %q, want %q", got, want)
+	}
+	got = writeString(t, rows, WithDiffBaseline(&base, DiffOmit))
+	if want := "status,amount\nclosed,\n,12\n"; got != want {
+		t.Errorf("omit: got %q, want %q", got, want)
+	}
+}
+
+type attrsBase struct {
+	Attrs map[string]int `csv:"attrs"`
+	Tags  []string       `csv:"tags"`
+}
+
+type attrsModel struct {
+	attrsBase
+	Name string `csv:"name"`
+}
+
+func TestEmbeddedStructMap(t *testing.T) {
+	rows := []attrsModel{
+		{attrsBase{map[string]int{"b": 2, "a": 1}, []string{"x", "y"}}, "n"},
+		{Name: "m"},
+	}
+	got := writeString(t, rows, WithMapSeparator(" "), WithSliceSeparator("/"))
+	if want := "attrs,tags,name\na=1 b=2,x/y,n\n,,m\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type describeAddress struct {
+	City string `csv:"city"`
+}
+
+type describeUser struct {
+	Name    string          `csv:"name"`
+	Address describeAddress `csv:"address"`
+}
+
+func TestDescribeRow(t *testing.T) {
+	got, err := DescribeRow(&describeUser{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"name": "a", "address.city": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteToPart(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := WriteToPart(mw, "file", "users.csv", []sectionUser{{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mr := multipart.NewReader(&body, mw.Boundary())
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part.FormName() != "file" || part.FileName() != "users.csv" {
+		t.Errorf("got part %q file %q", part.FormName(), part.FileName())
+	}
+	if ct, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); ct != "text/csv" {
+		t.Errorf("got content type %q", ct)
+	}
+	records, err := csv.NewReader(part).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"name"}, {"a"}}; !reflect.DeepEqual(records, want) {
+		t.Errorf("got %q, want %q", records, want)
+	}
+}
+
+func TestLeadingCommentOrder(t *testing.T) {
+	comment := WithLeadingCommentFunc(func(elemType reflect.Type) string {
+		return "# struct: " + elemType.Name()
+	})
+	got := writeString(t, []sectionUser{{"a"}}, WithBOM(), comment)
+	if want := "\ufeff# struct: sectionUser\nname\na\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type timesRow struct {
+	At   []time.Time  `csv:"at"`
+	Ptrs []*time.Time `csv:"ptrs"`
+}
+
+func TestTimeSlices(t *testing.T) {
+	a := time.Date(2024, 6, 12, 9, 30, 0, 0, time.UTC)
+	b := a.Add(24 * time.Hour)
+	rows := []timesRow{
+		{At: []time.Time{a, b}, Ptrs: []*time.Time{&a, nil, &b}},
+		{At: []time.Time{}, Ptrs: []*time.Time{}},
+	}
+	got := writeString(t, rows)
+	want := "at,ptrs\n" +
+		"2024-06-12 09:30|2024-06-13 09:30,2024-06-12 09:30||2024-06-13 09:30\n" +
+		",\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWithHeaders(t *testing.T) {
+	rows := []sectionOrder{{1, 2}}
+	got := writeString(t, rows, WithHeaders([]string{"Order ID", "Total"}))
+	if want := "Order ID,Total\n1,2\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	err := Write(&bytes.Buffer{}, rows, WithHeaders([]string{"Order ID"}))
+	if err == nil || !strings.Contains(err.Error(), "got 1 headers for 2 columns") {
+		t.Errorf("mismatch: got %v", err)
+	}
+}
+
+type jsonFieldsRow struct {
+	Roles []string   `csv:"roles"`
+	User  inlineUser `csv:"user"`
+	Note  string     `csv:"note"`
+}
+
+func TestJSONFields(t *testing.T) {
+	rows := []jsonFieldsRow{{Roles: []string{"a", "b"}, User: inlineUser{"x", 1}, Note: "n"}}
+	got := writeString(t, rows, WithJSONFields([]string{"Roles", "User"}))
+	want := "roles,user,note\n" +
+		`"[""a"",""b""]","{""Name"":""x"",""Age"":1}",n` + "\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type noColumns struct {
+	Ignored string `csv:"-"`
+	hidden  int
+}
+
+func TestNoColumns(t *testing.T) {
+	var b bytes.Buffer
+	err := Write(&b, []noColumns{{}})
+	if !errors.Is(err, ErrNoColumns) {
+		t.Errorf("got %v, want ErrNoColumns", err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("got output %q", b.String())
+	}
+}
+
+type formattedOrder struct {
+	Amount float64 `csv:"amount"`
+}
+
+type formattedRow struct {
+	Order formattedOrder `csv:"order"`
+	Fee   float64        `csv:"fee"`
+}
+
+func TestFieldFormatterNestedPath(t *testing.T) {
+	rows := []formattedRow{{Order: formattedOrder{Amount: 3.5}, Fee: 0.25}}
+	got := writeString(t, rows,
+		WithFieldFormatter("Order.Amount", func(v reflect.Value) (string, error) {
+			return strconv.FormatFloat(v.Float(), 'f', 2, 64) + " LYD", nil
+		}),
+	)
+	if want := "order.amount,fee\n3.50 LYD,0.25\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type optTime struct {
+	Time time.Time
+	Set  bool
+}
+
+type optTimeRow struct {
+	At  optTime  `csv:"at"`
+	Ptr *optTime `csv:"ptr"`
+}
+
+func TestOptionalTimeWrapper(t *testing.T) {
+	at := time.Date(2024, 6, 12, 9, 30, 0, 0, time.UTC)
+	format := WithTypeFormatter(
+		reflect.TypeOf(optTime{}),
+		func(v reflect.Value) (string, error) {
+			opt := v.Interface().(optTime)
+			if !opt.Set {
+				return "", nil
+			}
+			return opt.Time.Format(time.DateOnly), nil
+		},
+	)
+	rows := []optTimeRow{
+		{At: optTime{at, true}, Ptr: &optTime{at, true}},
+		{At: optTime{at, false}},
+	}
+	if got, want := writeString(t, rows, format), "at,ptr\n2024-06-12,2024-06-12\n,\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type trimmedRow struct {
+	Name    string           `csv:"name"`
+	Note    string           `csv:"note"`
+	Address *describeAddress `csv:"address"`
+}
+
+func TestTrimEmptyColumns(t *testing.T) {
+	rows := []trimmedRow{{Name: "a"}, {Name: "b"}}
+	got := writeString(t, rows, WithTrimEmptyColumns(true))
+	if want := "name\na\nb\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	rows[0].Note = "n"
+	got = writeString(t, rows, WithTrimEmptyColumns(true))
+	if want := "name,note\na,n\nb,\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteToZip(t *testing.T) {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	modified := time.Date(2024, 6, 12, 9, 30, 0, 0, time.UTC)
+	err := WriteToZip(zw, "users.csv", []sectionUser{{"a"}}, WithLastModified(modified))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "users.csv" {
+		t.Fatalf("got entries %v", zr.File)
+	}
+	if got := zr.File[0].Modified; !got.Equal(modified) {
+		t.Errorf("got modified %v, want %v", got, modified)
+	}
+	f, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"name"}, {"a"}}; !reflect.DeepEqual(records, want) {
+		t.Errorf("got %q, want %q", records, want)
+	}
+}
+
+type zeroNumbersRow struct {
+	Count int     `csv:"count"`
+	Price float64 `csv:"price"`
+	Size  uint    `csv:"size"`
+	Name  string  `csv:"name"`
+}
+
+func TestBlankZeroNumbers(t *testing.T) {
+	rows := []zeroNumbersRow{{}, {Count: 3, Price: 1.5, Size: 2, Name: "0"}}
+	got := writeString(t, rows, WithBlankZeroNumbers(true))
+	if want := "count,price,size,name\n,,,\n3,1.5,2,0\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type contactRow struct {
+	Email   string `csv:"email"`
+	Manager string `csv:"manager"`
+	Note    string `csv:"note"`
+}
+
+func TestValueReplacer(t *testing.T) {
+	rows := []contactRow{{"a@internal.example", "b@internal.example", "c@public.example"}}
+	got := writeString(t, rows,
+		WithValueReplacer(strings.NewReplacer("@internal.example", "@redacted")),
+	)
+	want := "email,manager,note\na@redacted,b@redacted,c@public.example\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type typedRow struct {
+	Name    string          `csv:"name"`
+	Count   int             `csv:"count"`
+	Price   float64         `csv:"price"`
+	Active  bool            `csv:"active"`
+	At      time.Time       `csv:"at"`
+	Address describeAddress `csv:"address"`
+}
+
+func TestTypeHeaderRow(t *testing.T) {
+	got := writeString(t, []typedRow{{}}, WithTypeHeaderRow(true))
+	records, err := csv.NewReader(strings.NewReader(got)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"name", "count", "price", "active", "at", "address.city"},
+		{"string", "int", "float", "bool", "datetime", "string"},
+	}
+	if !reflect.DeepEqual(records[:2], want) {
+		t.Errorf("got %q, want %q", records[:2], want)
+	}
+	if len(records[2]) != len(records[1]) {
+		t.Errorf("got %d cells for %d types", len(records[2]), len(records[1]))
+	}
+}
+
+type timestamp time.Time
+
+type timestampRow struct {
+	At  timestamp  `csv:"at"`
+	Ptr *timestamp `csv:"ptr"`
+}
+
+func TestDefinedTimeType(t *testing.T) {
+	at := timestamp(time.Date(2024, 6, 12, 9, 30, 0, 0, time.UTC))
+	got := writeString(t, []timestampRow{{At: at, Ptr: &at}})
+	if want := "at,ptr\n2024-06-12 09:30,2024-06-12 09:30\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteSeq(t *testing.T) {
+	var b bytes.Buffer
+	seq := slices.Values([]sectionUser{{"a"}, {"b"}, {"c"}})
+	if err := WriteSeq(&b, seq); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "name\na\nb\nc\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	b.Reset()
+	if err := WriteSeq(&b, slices.Values([]sectionUser(nil))); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "name\n"; got != want {
+		t.Errorf("empty: got %q, want %q", got, want)
+	}
+}
+
+type nilModeRow struct {
+	Name    string           `csv:"name"`
+	Address *describeAddress `csv:"address"`
+}
+
+type treeNode struct {
+	Name   string    `csv:"name"`
+	Parent *treeNode `csv:"parent"`
+}
+
+func TestNilStructModes(t *testing.T) {
+	rows := []nilModeRow{{Name: "a"}, {Name: "b", Address: &describeAddress{City: "c"}}}
+	got := writeString(t, rows, WithNilStructMode(NilStructExpand))
+	if want := "name,address.city\na,\nb,c\n"; got != want {
+		t.Errorf("expand: got %q, want %q", got, want)
+	}
+	got = writeString(t, rows, WithNilStructMode(NilStructCollapse))
+	want := "name,address\na,\nb,\"{\"\"City\"\":\"\"c\"\"}\"\n"
+	if got != want {
+		t.Errorf("collapse: got %q, want %q", got, want)
+	}
+}
+
+func TestValidateCycle(t *testing.T) {
+	err := Validate(reflect.TypeOf(treeNode{}))
+	if err == nil || !strings.Contains(err.Error(), "cyclic") {
+		t.Errorf("got %v, want a cyclic nested struct error", err)
+	}
+}
+
+type excelRow struct {
+	Zip   string `csv:"zip,exceltext"`
+	Phone string `csv:"phone"`
+}
+
+func TestExcelText(t *testing.T) {
+	got := writeString(t, []excelRow{{"01234", "0912"}})
+	if want := "zip,phone\n\"=\"\"01234\"\"\",0912\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type chanRow struct {
+	Name   string   `csv:"name"`
+	Events chan int `csv:"events"`
+}
+
+func TestChanField(t *testing.T) {
+	rows := []chanRow{{Name: "a", Events: make(chan int)}, {Name: "b"}}
+	if got, want := writeString(t, rows), "name,events\na,\nb,\n"; got != want {
+		t.Errorf("lenient: got %q, want %q", got, want)
+	}
+	err := Write(&bytes.Buffer{}, rows, WithStrict(true))
+	if !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("strict: got %v, want ErrUnsupportedType", err)
+	}
+}
+
+type localeKey struct{}
+
+type statusRow struct {
+	Status string `csv:"status"`
+}
+
+func TestFormatterContext(t *testing.T) {
+	labels := map[string]map[string]string{"ar": {"active": "نشط"}}
+	ctx := context.WithValue(context.Background(), localeKey{}, "ar")
+	got := writeString(t, []statusRow{{"active"}},
+		WithContext(ctx),
+		WithFieldFormatterContext("Status", func(ctx context.Context, v reflect.Value) (string, error) {
+			lang, _ := ctx.Value(localeKey{}).(string)
+			return labels[lang][v.String()], nil
+		}),
+	)
+	if want := "status\nنشط\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRowFilter(t *testing.T) {
+	rows := []sectionOrder{{1, 10}, {2, 0}, {3, 30}}
+	keep := WithRowFilter(func(v any) bool { return v.(sectionOrder).Total > 0 })
+	got := writeString(t, rows, keep, WithRowNumbers("#", false))
+	if want := "#,id,total\n1,1,10\n2,3,30\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type translatedRow struct {
+	Name    string          `csv:"user.name"`
+	Age     int             `csv:"user.age"`
+	Address describeAddress `csv:"address"`
+}
+
+func TestHeaderTranslator(t *testing.T) {
+	labels := map[string]string{"user.name": "الاسم", "user.age": "العمر", "city": "المدينة"}
+	got := writeString(t, []translatedRow{{"a", 1, describeAddress{"b"}}},
+		WithHeaderTranslator(func(key string) string { return labels[key] }),
+	)
+	if want := "الاسم,العمر,address.المدينة\na,1,b\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type enumStatus int
+
+func (s enumStatus) String() string {
+	return [...]string{"inactive", "active"}[s]
+}
+
+type embeddedStringerRow struct {
+	fmt.Stringer `csv:"status"`
+	Name         string `csv:"name"`
+}
+
+func TestEmbeddedInterface(t *testing.T) {
+	rows := []embeddedStringerRow{{Stringer: enumStatus(1), Name: "a"}, {Name: "b"}}
+	got := writeString(t, rows)
+	if want := "status,name\nactive,a\n,b\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	rows := []translatedRow{{"a", 1, describeAddress{"b"}}, {"c", 2, describeAddress{}}}
+	var b bytes.Buffer
+	if err := WriteNDJSON(&b, rows); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines: %q", len(lines), b.String())
+	}
+	for i, line := range lines {
+		var object map[string]string
+		if err := json.Unmarshal([]byte(line), &object); err != nil {
+			t.Fatalf("line %d: %v", i+1, err)
+		}
+		for _, key := range []string{"user.name", "user.age", "address.city"} {
+			if _, ok := object[key]; !ok {
+				t.Errorf("line %d: missing key %q in %s", i+1, key, line)
+			}
+		}
+	}
+}
+
+func TestQuotePredicate(t *testing.T) {
+	rows := []contactRow{{"a@b", "m", "free text"}}
+	got := writeString(t, rows,
+		WithQuotePredicate(func(header, _ string) bool { return header == "note" }),
+	)
+	if want := "email,manager,note\na@b,m,\"free text\"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type scheduleChild struct {
+	Times []time.Time `csv:"times"`
+}
+
+type scheduleParent struct {
+	Child scheduleChild `csv:"child"`
+}
+
+func TestNestedTimeSlice(t *testing.T) {
+	a := time.Date(2024, 6, 12, 9, 30, 0, 0, time.UTC)
+	rows := []scheduleParent{{Child: scheduleChild{Times: []time.Time{a, a.Add(time.Hour)}}}}
+	got := writeString(t, rows,
+		WithTimeLayout("15:04"),
+		WithSliceSeparator(";"),
+		WithHeaderSeparator("/"),
+	)
+	if want := "child/times\n09:30;10:30\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type invertRow struct {
+	Disabled bool  `csv:"enabled,invert"`
+	Ptr      *bool `csv:"ptr_enabled,invert"`
+}
+
+func TestInvert(t *testing.T) {
+	disabled := false
+	rows := []invertRow{{Disabled: true, Ptr: &disabled}, {}}
+	got := writeString(t, rows, WithBoolStrings("Yes", "No"))
+	if want := "enabled,ptr_enabled\nNo,Yes\nYes,\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type pointerRow struct {
+	Addr uintptr        `csv:"addr"`
+	Ptr  unsafe.Pointer `csv:"ptr"`
+}
+
+func TestUintptrAndUnsafePointer(t *testing.T) {
+	n := 1
+	rows := []pointerRow{{Addr: 4096, Ptr: unsafe.Pointer(&n)}}
+	if got, want := writeString(t, rows), "addr,ptr\n4096,\n"; got != want {
+		t.Errorf("lenient: got %q, want %q", got, want)
+	}
+	err := Write(&bytes.Buffer{}, rows, WithStrict(true))
+	if !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("strict: got %v, want ErrUnsupportedType", err)
+	}
+}
+
+func TestRange(t *testing.T) {
+	rows := []sectionOrder{{1, 1}, {2, 2}, {3, 3}, {4, 4}}
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{"window", []Option{WithRange(1, 2)}, "id,total\n2,2\n3,3\n"},
+		{"out of range", []Option{WithRange(10, 2)}, "id,total\n"},
+		{"to end", []Option{WithRange(2, 0)}, "id,total\n3,3\n4,4\n"},
+		{
+			"offset numbers",
+			[]Option{WithRange(1, 2), WithRowNumbers("#", false), WithRangeOffsetNumbers(true)},
+			"#,id,total\n2,2,2\n3,3,3\n",
+		},
+		{
+			"numbers from 1",
+			[]Option{WithRange(1, 2), WithRowNumbers("#", false)},
+			"#,id,total\n1,2,2\n2,3,3\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := writeString(t, rows, tt.opts...); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type money float64
+
+type moneyRow struct {
+	Price money `csv:"price"`
+}
+
+func TestDefinedFloatType(t *testing.T) {
+	rows := []moneyRow{{19.5}}
+	if got, want := writeString(t, rows, WithFloatPrecision(2)), "price\n19.50\n"; got != want {
+		t.Errorf("precision: got %q, want %q", got, want)
+	}
+	got := writeString(t, rows,
+		WithTypeFormatter(reflect.TypeOf(money(0)), func(v reflect.Value) (string, error) {
+			return strconv.FormatFloat(v.Float(), 'f', 2, 64) + " SAR", nil
+		}),
+	)
+	if want := "price\n19.50 SAR\n"; got != want {
+		t.Errorf("formatter: got %q, want %q", got, want)
+	}
+}
+
+type measurementRow struct {
+	Sample string  `csv:"sample"`
+	Mass   float64 `csv:"mass,unit=kg"`
+	Temp   float64 `csv:"temp,unit=°C"`
+}
+
+func TestUnitsRow(t *testing.T) {
+	got := writeString(t, []measurementRow{{"a", 1.5, 20}}, WithUnitsRow(true))
+	if want := "sample,mass,temp\n,kg,°C\na,1.5,20\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRequiredColumns(t *testing.T) {
+	rows := []sectionOrder{{1, 2}}
+	got := writeString(t, rows, WithRequiredColumns([]string{"total", "id"}))
+	if want := "id,total\n1,2\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var b bytes.Buffer
+	err := Write(&b, rows, WithRequiredColumns([]string{"id", "amount", "currency"}))
+	if err == nil || !strings.Contains(err.Error(), `"amount"`) ||
+		!strings.Contains(err.Error(), `"currency"`) {
+		t.Errorf("missing: got %v", err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("missing: got output %q", b.String())
+	}
+}
+
+type callbackRow struct {
+	Name     string       `csv:"name"`
+	OnChange func(string) `csv:"on_change"`
+}
+
+func TestFuncFieldIgnored(t *testing.T) {
+	rows := []callbackRow{{Name: "a", OnChange: func(string) {}}, {Name: "b"}}
+	for _, strict := range []bool{false, true} {
+		if got, want := writeString(t, rows, WithStrict(strict)), "name\na\nb\n"; got != want {
+			t.Errorf("strict %v: got %q, want %q", strict, got, want)
+		}
+	}
+}
+
+// cancelWriter cancels the export once it has been flushed after times
+type cancelWriter struct {
+	flushRecorder
+	after  int
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Flush() {
+	w.flushRecorder.Flush()
+	if len(w.flushes) == w.after {
+		w.cancel()
+	}
+}
+
+func TestGzipFlushCancel(t *testing.T) {
+	const total = 50000
+	rows := make([]observedRow, total)
+	for i := range rows {
+		rows[i].N = i
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &cancelWriter{after: 5, cancel: cancel}
+	err := WriteContext(ctx, w, rows,
+		WithGzip(gzip.BestSpeed),
+		WithFlushEvery(1000),
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if len(w.flushes) < w.after {
+		t.Errorf("got %d flushes, want at least %d", len(w.flushes), w.after)
+	}
+
+	zr, err := gzip.NewReader(&w.Buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(zr).ReadAll()
+	if err != nil {
+		t.Fatalf("truncated stream is not valid: %v", err)
+	}
+	if n := len(records) - 1; n < w.after*1000 || n >= total {
+		t.Errorf("got %d rows, want a truncated export", n)
+	}
+	for i, record := range records[1:] {
+		if record[0] != strconv.Itoa(i) {
+			t.Fatalf("row %d: got %q", i, record)
+		}
+	}
+}
+
+func TestEscapeNewlines(t *testing.T) {
+	rows := []contactRow{{Email: "a", Note: "line 1\nline 2\r\nline 3"}}
+	got := writeString(t, rows)
+	if want := "email,manager,note\na,,\"line 1\nline 2\r\nline 3\"\n"; got != want {
+		t.Errorf("off: got %q, want %q", got, want)
+	}
+	got = writeString(t, rows, WithEscapeNewlines(true))
+	if want := "email,manager,note\na,,line 1\\nline 2\\nline 3\n"; got != want {
+		t.Errorf("on: got %q, want %q", got, want)
+	}
+	got = writeString(t, rows, WithEscapeNewlines(true), WithNewlineToken(" / "))
+	if want := "email,manager,note\na,,line 1 / line 2 / line 3\n"; got != want {
+		t.Errorf("token: got %q, want %q", got, want)
+	}
+}
+
+type stringIDRow struct {
+	ID     int  `csv:"id,string"`
+	Count  int  `csv:"count"`
+	Active bool `csv:"active,string"`
+}
+
+func TestStringTagOption(t *testing.T) {
+	got := writeString(t, []stringIDRow{{ID: 7, Count: 3, Active: true}})
+	if want := "id,count,active\n\"7\",3,\"true\"\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRecordHook(t *testing.T) {
+	rows := []sectionOrder{{1, 10}, {2, 0}, {3, 30}}
+	got := writeString(t, rows, WithRecordHook(func(record []string) ([]string, error) {
+		switch record[1] {
+		case "total":
+			return []string{"ID", "TOTAL"}, nil
+		case "0":
+			return nil, nil
+		}
+		return record, nil
+	}))
+	if want := "ID,TOTAL\n1,10\n3,30\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	errHook := errors.New("hook failed")
+	err := Write(&bytes.Buffer{}, rows, WithRecordHook(func([]string) ([]string, error) {
+		return nil, errHook
+	}))
+	if !errors.Is(err, errHook) {
+		t.Errorf("got %v, want %v", err, errHook)
+	}
+}
+
+func TestWriteIterSyncMap(t *testing.T) {
+	var store sync.Map
+	for _, o := range []sectionOrder{{2, 20}, {1, 10}, {3, 30}} {
+		store.Store(o.ID, o)
+	}
+
+	var snapshot []sectionOrder
+	store.Range(func(_, v any) bool {
+		snapshot = append(snapshot, v.(sectionOrder))
+		return true
+	})
+	slices.SortFunc(snapshot, func(a, b sectionOrder) int { return a.ID - b.ID })
+
+	var b bytes.Buffer
+	next := func() (any, bool) {
+		if len(snapshot) == 0 {
+			return nil, false
+		}
+		v := snapshot[0]
+		snapshot = snapshot[1:]
+		return v, true
+	}
+	if err := WriteIter(&b, next); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "id,total\n1,10\n2,20\n3,30\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type ignoreRow struct {
+	Name   string `csv:"name"`
+	Secret string `csv:"skip"`
+	Dash   string `csv:"-"`
+}
+
+func TestIgnoreValue(t *testing.T) {
+	rows := []ignoreRow{{"a", "s", "d"}}
+	if got, want := writeString(t, rows), "name,skip\na,s\n"; got != want {
+		t.Errorf("default: got %q, want %q", got, want)
+	}
+	if got, want := writeString(t, rows, WithIgnoreValue("skip")), "name,-\na,d\n"; got != want {
+		t.Errorf("custom: got %q, want %q", got, want)
+	}
+}
+
+type pointerSliceRow struct {
+	Ints    []*int    `csv:"ints"`
+	Strings []*string `csv:"strings"`
+}
+
+func TestPointerSlices(t *testing.T) {
+	one, two := 1, 2
+	a, b := "a", "b"
+	rows := []pointerSliceRow{{Ints: []*int{&one, nil, &two}, Strings: []*string{&a, &b}}}
+	if got, want := writeString(t, rows), "ints,strings\n1||2,a|b\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEmptyInputBehavior(t *testing.T) {
+	var empty []sectionOrder
+	if got, want := writeString(t, empty), "id,total\n"; got != want {
+		t.Errorf("header only: got %q, want %q", got, want)
+	}
+	got := writeString(t, empty, WithEmptyInputBehavior(EmptyInputFile), WithBOM())
+	if got != "" {
+		t.Errorf("empty file: got %q", got)
+	}
+
+	var b bytes.Buffer
+	err := Write(&b, empty, WithEmptyInputBehavior(EmptyInputError))
+	if !errors.Is(err, ErrEmptyInput) || b.Len() != 0 {
+		t.Errorf("error: got %v and %q", err, b.String())
+	}
+}
+
+type payloadRow struct {
+	Text []byte `csv:"text,bytes=string"`
+	Raw  []byte `csv:"raw"`
+}
+
+func TestBytesString(t *testing.T) {
+	payload := []byte(`{"a":1}`)
+	got := writeString(t, []payloadRow{{payload, payload}})
+	if want := "text,raw\n\"{\"\"a\"\":1}\",eyJhIjoxfQ==\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSetDefaultOptions(t *testing.T) {
+	t.Cleanup(func() { SetDefaultOptions() })
+	SetDefaultOptions(WithBoolStrings("Y", "N"), WithHeaderSeparator("/"))
+
+	rows := []invertRow{{Disabled: false}}
+	if got, want := writeString(t, rows), "enabled,ptr_enabled\nY,\n"; got != want {
+		t.Errorf("defaults: got %q, want %q", got, want)
+	}
+	got := writeString(t, rows, WithBoolStrings("yes", "no"))
+	if want := "enabled,ptr_enabled\nyes,\n"; got != want {
+		t.Errorf("override: got %q, want %q", got, want)
+	}
+
+	SetDefaultOptions()
+	if got, want := writeString(t, rows), "enabled,ptr_enabled\ntrue,\n"; got != want {
+		t.Errorf("reset: got %q, want %q", got, want)
+	}
+}
+
+type groupedAddress struct {
+	City string `csv:"city"`
+	Zip  string `csv:"zip"`
+}
+
+type groupedUser struct {
+	Name    string         `csv:"name"`
+	Address groupedAddress `csv:"address"`
+}
+
+func TestGroupedHeaders(t *testing.T) {
+	got := writeString(t, []groupedUser{{"a", groupedAddress{"b", "c"}}}, WithGroupedHeaders(true))
+	if want := ",address,\nname,city,zip\na,b,c\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type scaledRow struct {
+	Amount int64 `csv:"amount,scale=2"`
+	Fils   int   `csv:"fils,scale=3"`
+}
+
+func TestScale(t *testing.T) {
+	rows := []scaledRow{{1999, 1500}, {-5, 1}, {100, 0}}
+	got := writeString(t, rows)
+	if want := "amount,fils\n19.99,1.500\n-0.05,0.001\n1.00,0.000\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	got := writeString(t, []sectionOrder{{1, 2.5}}, WithCSVWriter(func(w *csv.Writer) {
+		w.Comma = ';'
+		w.UseCRLF = true
+	}))
+	if want := "id;total\r\n1;2.5\r\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type addressBookRow struct {
+	Addresses map[string]describeAddress `csv:"addresses"`
+}
+
+func TestMapValueMode(t *testing.T) {
+	rows := []addressBookRow{{map[string]describeAddress{
+		"work": {City: "Benghazi"},
+		"home": {City: "Tripoli"},
+	}}}
+	if got, want := writeString(t, rows), "addresses\n\n"; got != want {
+		t.Errorf("blank: got %q, want %q", got, want)
+	}
+	got := writeString(t, rows, WithMapValueMode(MapValueJSON))
+	want := "addresses\n" +
+		`"home={""City"":""Tripoli""};work={""City"":""Benghazi""}"` + "\n"
+	if got != want {
+		t.Errorf("json: got %q, want %q", got, want)
+	}
+}
+
+type sortRow struct {
+	Status string `csv:"status"`
+	Amount int    `csv:"amount"`
+}
+
+func TestSortBy(t *testing.T) {
+	rows := []sortRow{{"b", 9}, {"a", 10}, {"b", 100}, {"a", 2}}
+	tests := []struct {
+		columns []string
+		want    string
+	}{
+		{[]string{"amount"}, "status,amount\na,2\nb,9\na,10\nb,100\n"},
+		{[]string{"-amount"}, "status,amount\nb,100\na,10\nb,9\na,2\n"},
+		{[]string{"status", "-amount"}, "status,amount\na,10\na,2\nb,100\nb,9\n"},
+	}
+	for _, tt := range tests {
+		if got := writeString(t, rows, WithSortBy(tt.columns...)); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.columns, got, tt.want)
+		}
+	}
+}
+
+func TestEachRow(t *testing.T) {
+	rows := []sectionOrder{{1, 10}, {2, 20}}
+	var got [][]string
+	err := EachRow(rows, func(record []string) error {
+		got = append(got, slices.Clone(record))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := csv.NewReader(strings.NewReader(writeString(t, rows))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = EachRow(rows, func([]string) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || calls != 1 {
+		t.Errorf("abort: got %v after %d calls", err, calls)
+	}
+}
+
+func TestMixedValuesAndPointers(t *testing.T) {
+	rows := []any{sectionOrder{1, 10}, &sectionOrder{2, 20}, sectionOrder{3, 30}}
+	if got, want := writeString(t, rows), "id,total\n1,10\n2,20\n3,30\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMaxCellLength(t *testing.T) {
+	tests := []struct {
+		cell   string
+		n      int
+		marker string
+		want   string
+	}{
+		{"abcdefghij", 5, "…", "abcd…"},
+		{"abcde", 5, "…", "abcde"},
+		{"مرحبا بالعالم", 5, "…", "مرحب…"},
+	}
+	for _, tt := range tests {
+		got := writeString(t, []sectionUser{{tt.cell}}, WithMaxCellLength(tt.n, tt.marker))
+		cell := strings.TrimSuffix(strings.TrimPrefix(got, "name\n"), "\n")
+		if cell != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.cell, cell, tt.want)
+		}
+		if !utf8.ValidString(cell) || utf8.RuneCountInString(cell) > tt.n {
+			t.Errorf("%q: got %q, not valid or longer than %d runes", tt.cell, cell, tt.n)
+		}
+	}
+}
+
+type eventRow struct {
+	At  time.Time  `csv:"at"`
+	Ptr *time.Time `csv:"ptr"`
+}
+
+func TestTimeFormatter(t *testing.T) {
+	months := []string{"يناير", "فبراير", "مارس", "أبريل", "مايو", "يونيو"}
+	format := WithTimeFormatter(func(t time.Time) string {
+		return strconv.Itoa(t.Day()) + " " + months[t.Month()-1] + " " + strconv.Itoa(t.Year())
+	})
+	at := time.Date(2024, 6, 12, 9, 30, 0, 0, time.UTC)
+	rows := []eventRow{{At: at, Ptr: &at}, {At: at}}
+	if got, want := writeString(t, rows, format), "at,ptr\n12 يونيو 2024,12 يونيو 2024\n12 يونيو 2024,\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+}
+
+func TestChecksumTrailer(t *testing.T) {
+	got := writeString(t, []sectionOrder{{1, 10}, {2, 20}}, WithChecksumTrailer(true))
+	i := strings.LastIndex(strings.TrimSuffix(got, "\n"), "\n") + 1
+	body, trailer := got[:i], got[i:]
+	if body != "id,total\n1,10\n2,20\n" {
+		t.Errorf("got body %q", body)
+	}
+	sum := sha256.Sum256([]byte(body))
+	if want := "#rows=2,sha256=" + hex.EncodeToString(sum[:]) + "\n"; trailer != want {
+		t.Errorf("got trailer %q, want %q", trailer, want)
+	}
+}
+
+func TestRowNumbersOriginalIndex(t *testing.T) {
+	rows := []sectionOrder{{1, 0}, {2, 20}, {3, 0}, {4, 40}}
+	got := writeString(t, rows,
+		WithRowFilter(func(v any) bool { return v.(sectionOrder).Total > 0 }),
+		WithRowNumbers("#", true),
+	)
+	if want := "#,id,total\n2,2,20\n4,4,40\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTranspose(t *testing.T) {
+	rows := []groupedUser{{"a", groupedAddress{"b", "c"}}, {"d", groupedAddress{"e", "f"}}}
+	records, err := csv.NewReader(strings.NewReader(writeString(t, rows, WithTranspose(true)))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"name", "a", "d"},
+		{"address.city", "b", "e"},
+		{"address.zip", "c", "f"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("got %q, want %q", records, want)
+	}
+}
+
+type tags []string
+
+type meta map[string]int
+
+type collectionRow struct {
+	Tags tags `csv:"tags"`
+	Meta meta `csv:"meta"`
+}
+
+func TestDefinedCollectionTypes(t *testing.T) {
+	rows := []collectionRow{{tags{"a", "b"}, meta{"y": 2, "x": 1}}}
+	if got, want := writeString(t, rows), "tags,meta\na|b,x=1;y=2\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFloatAuto(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{1.5, "1.5"},
+		{1000000, "1000000"},
+		{0.0001, "0.0001"},
+		{3.14159, "3.14159"},
+		{1e21, "1e+21"},
+		{1e-7, "1e-07"},
+	}
+	for _, tt := range tests {
+		got := writeString(t, []moneyRow{{money(tt.value)}}, WithFloatFormat(FloatAuto), WithFloatPrecision(2))
+		if got != "price\n"+tt.want+"\n" {
+			t.Errorf("%v: got %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestEOFMarker(t *testing.T) {
+	got := writeString(t, []sectionOrder{{1, 10}}, WithChecksumTrailer(true), WithEOFMarker("# EOF"))
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %q", got)
+	}
+	if !strings.HasPrefix(lines[2], "#rows=1,sha256=") || lines[3] != "# EOF" {
+		t.Errorf("got trailer %q and marker %q", lines[2], lines[3])
+	}
+}
+
+type noprefixRow struct {
+	User    sectionUser     `csv:"user,noprefix"`
+	Address describeAddress `csv:"address"`
+}
+
+type noprefixCollision struct {
+	Name string      `csv:"name"`
+	User sectionUser `csv:"user,noprefix"`
+}
+
+func TestNoprefix(t *testing.T) {
+	got := writeString(t, []noprefixRow{{sectionUser{"a"}, describeAddress{"b"}}})
+	if want := "name,address.city\na,b\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	err := Validate(reflect.TypeOf(noprefixCollision{}))
+	if err == nil || !strings.Contains(err.Error(), `duplicate header "name"`) {
+		t.Errorf("collision: got %v", err)
+	}
+}
+
+func TestWriteValues(t *testing.T) {
+	values := []reflect.Value{
+		reflect.ValueOf(sectionOrder{1, 10}),
+		reflect.ValueOf(sectionOrder{2, 20}),
+	}
+	var b bytes.Buffer
+	if err := WriteValues(&b, values); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "id,total\n1,10\n2,20\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	b.Reset()
+	if err := WriteValues(&b, nil, WithTypeHint(reflect.TypeOf(&sectionOrder{}))); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.String(), "id,total\n"; got != want {
+		t.Errorf("type hint: got %q, want %q", got, want)
+	}
+	if err := WriteValues(&b, nil); err == nil {
+		t.Error("empty without type hint: got no error")
+	}
+	if err := WriteValues(&b, []reflect.Value{values[0], {}}); err == nil {
+		t.Error("invalid value: got no error")
+	}
+}
+
+type lineItem struct {
+	SKU string `csv:"sku"`
+	Qty int    `csv:"qty"`
+}
+
+type explodedOrder struct {
+	ID    int        `csv:"id"`
+	Items []lineItem `csv:"item"`
+}
+
+func TestExplodeChildIndex(t *testing.T) {
+	orders := []explodedOrder{
+		{1, []lineItem{{"a", 1}, {"b", 2}}},
+		{2, nil},
+		{3, []lineItem{{"c", 3}}},
+	}
+	got := writeString(t, orders, WithExplode("Items"), WithExplodeIndex("childIndex"))
+	want := "id,childIndex,item.sku,item.qty\n" +
+		"1,1,a,1\n" +
+		"1,2,b,2\n" +
+		"2,,,\n" +
+		"3,1,c,3\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	err := Write(&bytes.Buffer{}, orders, WithExplode("ID"))
+	if err == nil || !strings.Contains(err.Error(), "not a slice") {
+		t.Errorf("not a slice: got %v", err)
+	}
+}
+
+func TestStripReplacementChar(t *testing.T) {
+	rows := []sectionUser{{"caf�é�"}}
+	if got, want := writeString(t, rows), "name\ncaf�é�\n"; got != want {
+		t.Errorf("off: got %q, want %q", got, want)
+	}
+	if got, want := writeString(t, rows, WithStripReplacementChar(true)), "name\ncafé\n"; got != want {
+		t.Errorf("on: got %q, want %q", got, want)
+	}
+}
+
+func TestColumnRename(t *testing.T) {
+	rows := []translatedRow{{"a", 1, describeAddress{"b"}}}
+	got := writeString(t, rows, WithColumnRename(map[string]string{
+		"user.name":    "full_name",
+		"address.city": "city",
+	}))
+	if want := "full_name,user.age,city\na,1,b\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	err := Validate(reflect.TypeOf(translatedRow{}), WithColumnRename(map[string]string{
+		"user.name": "user.age",
+	}))
+	if err == nil || !strings.Contains(err.Error(), `duplicate header "user.age"`) {
+		t.Errorf("collision: got %v", err)
+	}
+}
+
+type splitTimeRow struct {
+	ID int       `csv:"id"`
+	TS time.Time `csv:"ts,split=date|time"`
+	N  int       `csv:"n"`
+}
+
+func TestSplitTime(t *testing.T) {
+	rows := []splitTimeRow{{1, time.Date(2024, 6, 12, 9, 30, 0, 0, time.UTC), 2}}
+	if got, want := writeString(t, rows), "id,ts.date,ts.time,n\n1,2024-06-12,09:30,2\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type flakyCell int
+
+func (c flakyCell) MarshalCSV() (string, error) {
+	if c < 0 {
+		return "", errors.New("negative " + strconv.Itoa(int(c)))
+	}
+	return strconv.Itoa(int(c)), nil
+}
+
+type flakyRow struct {
+	Value flakyCell `csv:"value"`
+	Name  string    `csv:"name"`
+}
+
+func TestCollectErrors(t *testing.T) {
+	rows := []flakyRow{{1, "a"}, {-2, "b"}, {3, "c"}, {-4, "d"}}
+	var b bytes.Buffer
+	err := Write(&b, rows, WithStrict(true), WithCollectErrors(true), WithErrorRowValue("#ERROR"))
+	if got, want := b.String(), "value,name\n1,a\n#ERROR,#ERROR\n3,c\n#ERROR,#ERROR\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(joined.Unwrap()) != 2 {
+		t.Fatalf("got %v, want two joined errors", err)
+	}
+	for _, msg := range []string{"negative -2", "negative -4"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("got %v, missing %q", err, msg)
+		}
+	}
+}
+
+func TestStripValueBOM(t *testing.T) {
+	rows := []sectionUser{{"\ufeffname \ufeff"}}
+	if got, want := writeString(t, rows, WithStripValueBOM(true)), "name\nname \ufeff\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := writeString(t, rows), "name\n\ufeffname \ufeff\n"; got != want {
+		t.Errorf("off: got %q, want %q", got, want)
+	}
+}
+
+func TestBuildHeaders(t *testing.T) {
+	opts := []Option{
+		WithColumnRename(map[string]string{"address.city": "city"}),
+		WithRowNumbers("#", false),
+		WithHeaderSeparator("/"),
+	}
+	got, err := BuildHeaders(reflect.TypeOf(&translatedRow{}), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(writeString(t, []translatedRow{{}}, opts...))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, records[0]) {
+		t.Errorf("got %q, want %q", got, records[0])
+	}
+}
+
+type permissionsRow struct {
+	Permissions uint32 `csv:"permissions"`
+}
+
+func TestBitmaskLabels(t *testing.T) {
+	labels := WithBitmaskLabels("Permissions", map[uint64]string{
+		0:      "none",
+		1 << 0: "read",
+		1 << 1: "write",
+	}, "|")
+	rows := []permissionsRow{{1<<0 | 1<<1 | 1<<4}, {0}}
+	if got, want := writeString(t, rows, labels), "permissions\nread|write|bit4\nnone\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got := writeString(t, rows, labels, WithBitmaskSkipUnlabeled(true))
+	if want := "permissions\nread|write\nnone\n"; got != want {
+		t.Errorf("skip unlabeled: got %q, want %q", got, want)
+	}
+}
+
+type decimal struct {
+	units int64
+	scale int
+}
+
+func (d decimal) String() string {
+	s := strconv.FormatInt(d.units, 10)
+	return s[:len(s)-d.scale] + "." + s[len(s)-d.scale:]
+}
+
+type priceRow struct {
+	Price decimal  `csv:"price"`
+	Ptr   *decimal `csv:"ptr"`
+}
+
+func TestDecimalStringer(t *testing.T) {
+	d := decimal{1999, 2}
+	rows := []priceRow{{Price: d, Ptr: &d}, {Price: decimal{100, 1}}}
+	if got, want := writeString(t, rows), "price,ptr\n19.99,19.99\n10.0,\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := writeString(t, rows[:1], WithTypeHeaderRow(true)), "price,ptr\nstring,string\n19.99,19.99\n"; got != want {
+		t.Errorf("type row: got %q, want %q", got, want)
+	}
+}
+
+func TestSelfReferencingPointer(t *testing.T) {
+	// a pointer back to its own type is one blank column, not a cycle error
+	nodes := []treeNode{{Name: "root"}, {Name: "leaf", Parent: &treeNode{Name: "root"}}}
+	if got, want := writeString(t, nodes), "name,parent\nroot,\nleaf,\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type enumRow struct {
+	Status enumStatus `csv:"status"`
+}
+
+func TestValueReceiverStringerInSliceElement(t *testing.T) {
+	got := writeString(t, []any{enumRow{Status: 1}, &enumRow{}}, WithNumberStringers(true))
+	want := "status\nactive\ninactive\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEmptyInputFileGzip(t *testing.T) {
+	// an empty gzip stream is still a valid gzip body
+	var b bytes.Buffer
+	err := Write(&b, []sectionOrder{}, WithEmptyInputBehavior(EmptyInputFile), WithGzip(gzip.BestSpeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := io.ReadAll(zr); err != nil || len(body) != 0 {
+		t.Errorf("got %q, %v", body, err)
+	}
+}
+
+func TestPadOverflowWithoutStrict(t *testing.T) {
+	err := Write(&bytes.Buffer{}, []padRow{{Left: "abcdefg", Right: 1}})
+	if !errors.Is(err, ErrPadOverflow) {
+		t.Errorf("got %v, want ErrPadOverflow", err)
+	}
+}
+
+func TestRowNumbersOriginalIndexNDJSON(t *testing.T) {
+	rows := []sectionOrder{{1, 0}, {2, 20}, {3, 0}, {4, 40}}
+	var b bytes.Buffer
+	err := WriteNDJSON(&b, rows,
+		WithRowFilter(func(v any) bool { return v.(sectionOrder).Total > 0 }),
+		WithRowNumbers("#", true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"#":"2","id":"2","total":"20"}` + "\n" + `{"#":"4","id":"4","total":"40"}` + "\n"
+	if got := b.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncoderBufferedOption(t *testing.T) {
+	// the streaming encoder can't sort rows it has already written
+	rows := []sortRow{{"b", 9}, {"a", 10}}
+	err := NewEncoder(&bytes.Buffer{}, WithSortBy("amount")).Encode(rows)
+	if !errors.Is(err, ErrBufferedOption) {
+		t.Errorf("got %v, want ErrBufferedOption", err)
+	}
+}
+
+func TestMaxCellLengthLongMarker(t *testing.T) {
+	got := writeString(t, []sectionUser{{"abcdefghij"}}, WithMaxCellLength(2, "..."))
+	if want := "name\n..\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBlankZeroTimes(t *testing.T) {
+	format := WithTimeFormatter(func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format(time.DateOnly)
+	})
+	rows := []eventRow{{}}
+	if got, want := writeString(t, rows, format), "at,ptr\n-,\n"; got != want {
+		t.Errorf("formatter: got %q, want %q", got, want)
+	}
+
+	// zero times are blanked the same way with a layout and a formatter
+	blank := WithBlankZeroTimes(true)
+	if got, want := writeString(t, rows, format, blank), "at,ptr\n,\n"; got != want {
+		t.Errorf("blank formatter: got %q, want %q", got, want)
+	}
+	if got, want := writeString(t, rows, WithTimeLayout(time.DateOnly), blank), "at,ptr\n,\n"; got != want {
+		t.Errorf("blank layout: got %q, want %q", got, want)
+	}
+}
+
+func TestObserverFlushEvery(t *testing.T) {
+	rows := make([]observedRow, 5)
+	var w flushRecorder
+	var stats Stats
+	err := Write(&w, rows, WithFlushEvery(2), WithObserver(func(s Stats) { stats = s }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{6, 10, 12}; !slices.Equal(w.flushes, want) {
+		t.Errorf("got flushes at %v, want %v", w.flushes, want)
+	}
+	if stats.RowCount != len(rows) || stats.ByteCount != 12 {
+		t.Errorf("got %+v, want %d rows of 12 bytes", stats, len(rows))
+	}
+}
+
+func TestNDJSONBufferedOption(t *testing.T) {
+	rows := []sortRow{{"b", 9}, {"a", 10}}
+	var b bytes.Buffer
+	err := WriteNDJSON(&b, rows, WithSortBy("amount"))
+	if !errors.Is(err, ErrBufferedOption) || b.Len() != 0 {
+		t.Errorf("got %v with %q, want ErrBufferedOption and no output", err, b.String())
+	}
+}
+
+func TestNDJSONEmptyInputError(t *testing.T) {
+	var b bytes.Buffer
+	err := WriteNDJSON(&b, []sortRow{}, WithEmptyInputBehavior(EmptyInputError))
+	if !errors.Is(err, ErrEmptyInput) || b.Len() != 0 {
+		t.Errorf("got %v with %q, want ErrEmptyInput and no output", err, b.String())
+	}
+}
+
+type durationRow struct {
+	Timeout time.Duration `csv:"timeout"`
+	Status  enumStatus    `csv:"status"`
+}
+
+func TestNumberStringers(t *testing.T) {
+	rows := []durationRow{{Timeout: time.Second, Status: 1}, {}}
+	// numeric types keep the number options unless asked for their method
+	got := writeString(t, rows, WithNumberGrouping(true), WithBlankZeroNumbers(true))
+	if want := "timeout,status\n\"1,000,000,000\",1\n,\n"; got != want {
+		t.Errorf("default: got %q, want %q", got, want)
+	}
+	got = writeString(t, rows, WithNumberStringers(true))
+	if want := "timeout,status\n1s,active\n0s,inactive\n"; got != want {
+		t.Errorf("stringers: got %q, want %q", got, want)
+	}
+}
+
+func TestEncoderWriteSectionRowNumbers(t *testing.T) {
+	var b bytes.Buffer
+	enc := NewEncoder(&b, WithRowNumbers("#", false))
+	if err := enc.WriteSection([]sectionUser{{"a"}, {"b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteSection([]sectionOrder{{1, 9.5}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Encode([]sectionOrder{{2, 3}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := "#,name\n1,a\n2,b\n\n#,id,total\n1,1,9.5\n2,2,3\n"
+	if got := b.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestValueReplacerMaxCellLength(t *testing.T) {
+	// the replaced cell is truncated, not the original
+	got := writeString(t, []sectionUser{{"abc"}},
+		WithValueReplacer(strings.NewReplacer("a", "aaaa")),
+		WithMaxCellLength(5, "…"),
+	)
+	if want := "name\naaaa…\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,115 @@
+package struct2csv
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tagOptions is the comma separated list of options following the header
+// name in a csv tag
+type tagOptions string
+
+// parseTag splits a csv tag into its header name and options, following the
+// encoding/json convention so `csv:"-,"` is a column literally named "-"
+func parseTag(tag string) (string, tagOptions) {
+	name, opts, _ := strings.Cut(tag, ",")
+	return name, tagOptions(opts)
+}
+
+// headerName returns the header name from the csv tag of field
+func headerName(field reflect.StructField) string {
+	name, _ := parseTag(field.Tag.Get("csv"))
+	return name
+}
+
+// Contains reports whether the options contain opt, a bare option like
+// "string" or the key of a key=value option like "inline"
+func (o tagOptions) Contains(opt string) bool {
+	_, ok := o.Lookup(opt)
+	return ok
+}
+
+// Lookup returns the value of the key=value option named key, a bare option
+// has an empty value
+func (o tagOptions) Lookup(key string) (string, bool) {
+	s := string(o)
+	for s != "" {
+		var opt string
+		opt, s, _ = strings.Cut(s, ",")
+		name, value, _ := strings.Cut(opt, "=")
+		if name == key {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// fieldOptions returns the options of the csv tag of field
+func fieldOptions(field reflect.StructField) tagOptions {
+	_, opts := parseTag(field.Tag.Get("csv"))
+	return opts
+}
+
+// isInlineJSON reports whether field is tagged to be written as a single
+// compact JSON cell with the `inline=json` option
+func isInlineJSON(field reflect.StructField) bool {
+	inline, _ := fieldOptions(field).Lookup("inline")
+	return inline == "json"
+}
+
+// validateTagOptions reports tag option values that can not be applied
+func validateTagOptions(opts tagOptions) error {
+	if pad, ok := opts.Lookup("pad"); ok {
+		if n, err := strconv.Atoi(pad); err != nil || n <= 0 {
+			return fmt.Errorf("invalid pad option %q", pad)
+		}
+	}
+	if scale, ok := opts.Lookup("scale"); ok {
+		if n, err := strconv.Atoi(scale); err != nil || n < 0 || n > 19 {
+			return fmt.Errorf("invalid scale option %q", scale)
+		}
+	}
+	if align, ok := opts.Lookup("align"); ok && align != "left" && align != "right" {
+		return fmt.Errorf("invalid align option %q", align)
+	}
+	return nil
+}
+
+// ErrPadOverflow is returned under PadOverflowError for a value longer than
+// the width of its pad tag option
+var ErrPadOverflow = errors.New("value is longer than pad width")
+
+// padCell pads cell with spaces to the width of the pad tag option, aligned
+// left unless the align=right option is given, the width counts runes
+func padCell(cell string, opts tagOptions, cfg *config) (string, error) {
+	pad, ok := opts.Lookup("pad")
+	if !ok {
+		return cell, nil
+	}
+	width, _ := strconv.Atoi(pad)
+	n := utf8.RuneCountInString(cell)
+	if n > width {
+		if cfg.padOverflow != PadOverflowTruncate {
+			return "", fmt.Errorf("%w %d: %q", ErrPadOverflow, width, cell)
+		}
+		return string([]rune(cell)[:width]), nil
+	}
+	spaces := strings.Repeat(" ", width-n)
+	if align, _ := opts.Lookup("align"); align == "right" {
+		return spaces + cell, nil
+	}
+	return cell + spaces, nil
+}
+
+// excelText wraps a non-empty cell as the Excel text formula ="cell" when
+// the exceltext tag option is given
+func excelText(cell string, opts tagOptions) string {
+	if cell == "" || !opts.Contains("exceltext") {
+		return cell
+	}
+	return `="` + strings.ReplaceAll(cell, `"`, `""`) + `"`
+}
@@ -0,0 +1,36 @@
+package struct2csv
+
+import "fmt"
+
+// writeTransposed writes the headers as the first column and the rows, with
+// only the fields at the columns marked in columns, as the following ones
+// for WithTranspose returning the number of rows written
+func writeTransposed(
+	writer *recordWriter,
+	headers []string,
+	rows []record,
+	columns []bool,
+	cfg *config,
+) (int, error) {
+	if cfg.rowNumbers {
+		headers = append([]string{cfg.rowNumberHeader}, headers...)
+	}
+	writer.headers = headers
+	prepared := make([]record, len(rows))
+	for i, row := range rows {
+		prepared[i] = prepareRow(row.keep(columns), i, headers, cfg)
+	}
+
+	for j, header := range headers {
+		var transposed record
+		transposed.add(header, false)
+		for _, row := range prepared {
+			transposed.add(row.fields[j], row.quoted[j])
+		}
+		if err := writer.writeRow(transposed); err != nil {
+			return 0, fmt.Errorf("failed to write column %s: %w", header, err)
+		}
+	}
+	writer.rows = len(rows)
+	return len(rows), nil
+}
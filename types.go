@@ -0,0 +1,126 @@
+package struct2csv
+
+import "reflect"
+
+// typeRecord generates the WithTypeHeaderRow record of elemType with a type
+// token per column of headerRecord
+func typeRecord(elemType reflect.Type, cfg *config) []string {
+	types := extractColumns(elemType, "", cfg, nil, typeToken)
+	if cfg.explodeIndex >= 0 {
+		types = append(types, explodeColumns(cfg, "int", typeToken)...)
+	}
+	if cfg.catchAllIndex >= 0 {
+		types = append(types, "json")
+	}
+	return types
+}
+
+// unitRecord generates the WithUnitsRow record of elemType with the unit
+// tag option of every column of headerRecord, blank where there is none
+func unitRecord(elemType reflect.Type, cfg *config) []string {
+	units := extractColumns(elemType, "", cfg, nil, unit)
+	if cfg.explodeIndex >= 0 {
+		units = append(units, explodeColumns(cfg, "", unit)...)
+	}
+	if cfg.catchAllIndex >= 0 {
+		units = append(units, "")
+	}
+	return units
+}
+
+// extractColumns generates a cell per column of elemType walking the fields
+// the way extractHeaders does, cell returns the cell of the field at path
+func extractColumns(
+	elemType reflect.Type,
+	prefix string,
+	cfg *config,
+	parents []reflect.Type,
+	cell func(field reflect.StructField, path string, cfg *config) string,
+) []string {
+	parents = append(parents, elemType)
+
+	var cells []string
+	for i := 0; i < elemType.NumField(); i++ {
+		field := elemType.Field(i)
+		if cfg.skipField(elemType, i) {
+			continue
+		}
+
+		path := fieldPath(prefix, field.Name)
+		if cfg.expandNested(field, path, parents) {
+			if isEmbeddedStruct(field) {
+				path = prefix
+			}
+			subCells := extractColumns(
+				subStructType(field),
+				path,
+				cfg,
+				parents,
+				cell,
+			)
+			cells = append(cells, subCells...)
+		} else if parts := splitParts(field); parts != nil {
+			for range parts {
+				cells = append(cells, cell(field, path, cfg))
+			}
+		} else {
+			cells = append(cells, cell(field, path, cfg))
+		}
+	}
+	return cells
+}
+
+// unit returns the unit tag option of field
+func unit(field reflect.StructField, _ string, _ *config) string {
+	unit, _ := fieldOptions(field).Lookup("unit")
+	return unit
+}
+
+// typeToken returns the type token of the column of the field at path,
+// "string", "int", "float", "bool", "datetime" or "json"
+func typeToken(field reflect.StructField, path string, cfg *config) string {
+	if _, ok := cfg.fieldFormatters[path]; ok {
+		return "string"
+	}
+	if cfg.isJSONField(field, path) {
+		return "json"
+	}
+	if _, ok := cfg.enumLabels[path]; ok {
+		return "string"
+	}
+	if _, ok := cfg.bitmasks[path]; ok {
+		return "string"
+	}
+	if as, _ := fieldOptions(field).Lookup("as"); as == "char" {
+		return "string"
+	}
+
+	t := field.Type
+	for t.Kind() == reflect.Ptr {
+		if cfg.hasTypeFormatter(t) || implementsMarshaler(t) {
+			return "string"
+		}
+		t = t.Elem()
+	}
+	if cfg.hasTypeFormatter(t) || implementsMarshaler(t) || cfg.isTextType(t) {
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Uintptr:
+		if fieldOptions(field).Contains("scale") {
+			return "float"
+		}
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Bool:
+		return "bool"
+	case reflect.Struct:
+		if isTimeType(t) {
+			return "datetime"
+		}
+	}
+	return "string"
+}
@@ -0,0 +1,99 @@
+package struct2csv
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Validate checks that elemType, a struct or pointer to struct, can be
+// written with opts without producing any output, it returns the first
+// problem found: a cyclic nested struct, which Write writes as one blank
+// column, a field kind without a csv representation under WithStrict, or a
+// duplicate header
+//
+//	if err := struct2csv.Validate(reflect.TypeOf(Model{})); err != nil {
+//		log.Fatal(err)
+//	}
+func Validate(elemType reflect.Type, opts ...Option) error {
+	cfg := newConfig(opts)
+	if elemType == nil {
+		return errors.New("type is nil")
+	}
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return errors.New("type is not a struct")
+	}
+	if err := cfg.bind(elemType); err != nil {
+		return err
+	}
+	if err := validateFields(elemType, "", cfg, nil); err != nil {
+		return err
+	}
+	if cfg.explodeIndex >= 0 {
+		field := elemType.Field(cfg.explodeIndex)
+		err := validateFields(cfg.explodeType, field.Name, cfg, nil)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+	}
+
+	headers, err := headerRecord(elemType, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to extract headers: %w", err)
+	}
+	seen := make(map[string]bool, len(headers))
+	for _, header := range headers {
+		if seen[header] {
+			return fmt.Errorf("duplicate header %q", header)
+		}
+		seen[header] = true
+	}
+	return nil
+}
+
+// validateFields walks the fields of elemType the way extractHeaders does,
+// parents holds the nested struct types being walked to detect cycles
+func validateFields(
+	elemType reflect.Type,
+	prefix string,
+	cfg *config,
+	parents []reflect.Type,
+) error {
+	for _, parent := range parents {
+		if parent == elemType {
+			return fmt.Errorf("cyclic nested struct %s", elemType)
+		}
+	}
+	parents = append(parents, elemType)
+
+	for i := 0; i < elemType.NumField(); i++ {
+		field := elemType.Field(i)
+		if cfg.skipField(elemType, i) {
+			continue
+		}
+
+		path := fieldPath(prefix, field.Name)
+		if cfg.expandStruct(field, path) {
+			if isEmbeddedStruct(field) {
+				path = prefix
+			}
+			err := validateFields(subStructType(field), path, cfg, parents)
+			if err != nil {
+				return fmt.Errorf("field %s: %w", field.Name, err)
+			}
+		} else if cfg.strict &&
+			!cfg.isJSONField(field, path) &&
+			!isSupportedType(field.Type, cfg) {
+			return fmt.Errorf(
+				"field %s: %w: %s",
+				field.Name,
+				ErrUnsupportedType,
+				field.Type,
+			)
+		}
+	}
+	return nil
+}
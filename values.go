@@ -0,0 +1,51 @@
+package struct2csv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// WriteValues writes values, structs or pointers to structs all of the same
+// type, as csv to w like Write, the type is taken from the first value or
+// from WithTypeHint, which an empty values needs
+//
+//	err := struct2csv.WriteValues(w, rows, struct2csv.WithTypeHint(
+//		reflect.TypeOf(User{}),
+//	))
+func WriteValues(w io.Writer, values []reflect.Value, opts ...Option) error {
+	cfg := newConfig(opts)
+	elemType := cfg.typeHint
+	if elemType == nil {
+		if len(values) == 0 {
+			return errors.New(
+				"cannot resolve element type of empty values, use WithTypeHint",
+			)
+		}
+		if !values[0].IsValid() {
+			return errors.New("element 0 is invalid")
+		}
+		elemType = values[0].Type()
+	}
+
+	slice := reflect.MakeSlice(reflect.SliceOf(elemType), 0, len(values))
+	for i, value := range values {
+		if !value.IsValid() {
+			return fmt.Errorf("element %d is invalid", i)
+		}
+		if !value.CanInterface() {
+			return fmt.Errorf("element %d is from an unexported field", i)
+		}
+		if value.Type() != elemType {
+			return fmt.Errorf(
+				"element %d is %s, expected %s",
+				i,
+				value.Type(),
+				elemType,
+			)
+		}
+		slice = reflect.Append(slice, value)
+	}
+	return write(w, slice.Interface(), cfg)
+}
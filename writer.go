@@ -0,0 +1,415 @@
+package struct2csv
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// record is a csv record, quoted marks the fields that must be written
+// quoted even where encoding/csv would leave them bare and unchanged the
+// fields equal to the WithDiffBaseline baseline
+type record struct {
+	fields    []string
+	quoted    []bool
+	unchanged []bool
+
+	// index is the index of the element in the input the row was formatted
+	// from, before WithRange and WithRowFilter
+	index int
+}
+
+// add appends a field to the record
+func (r *record) add(field string, quoted bool) {
+	r.fields = append(r.fields, field)
+	r.quoted = append(r.quoted, quoted)
+	r.unchanged = append(r.unchanged, false)
+}
+
+// addUnchanged appends a blank field equal to the baseline
+func (r *record) addUnchanged() {
+	r.fields = append(r.fields, "")
+	r.quoted = append(r.quoted, false)
+	r.unchanged = append(r.unchanged, true)
+}
+
+// extend appends the fields of sub to the record
+func (r *record) extend(sub record) {
+	r.fields = append(r.fields, sub.fields...)
+	r.quoted = append(r.quoted, sub.quoted...)
+	r.unchanged = append(r.unchanged, sub.unchanged...)
+}
+
+// keep returns the record with only the fields at the columns marked in
+// columns
+func (r record) keep(columns []bool) record {
+	kept := record{index: r.index}
+	for i, ok := range columns {
+		if ok {
+			kept.fields = append(kept.fields, r.fields[i])
+			kept.quoted = append(kept.quoted, r.quoted[i])
+			kept.unchanged = append(kept.unchanged, r.unchanged[i])
+		}
+	}
+	return kept
+}
+
+// recordWriter writes records to w with a *csv.Writer, or a quoteWriter when
+// an option needs quoting that encoding/csv does not support, headers is
+// the last header record written and rows counts the row records written
+type recordWriter struct {
+	w       io.Writer
+	csv     *csv.Writer
+	quote   *quoteWriter
+	headers []string
+	rows    int
+
+	// section is the number of rows written before the current Encoder
+	// section, WithRowNumbers numbers every section from 1
+	section int
+
+	// dst is the io.Writer the records end up in, w is a gzip stream
+	// written into it under WithGzip
+	gzip *gzip.Writer
+	dst  io.Writer
+
+	// checksum hashes what is written to w for WithChecksumTrailer, body
+	// is w without the hash to write the trailer to
+	checksum hash.Hash
+	body     io.Writer
+
+	// hook is the WithRecordHook hook, sink receives the records instead
+	// of w for EachRow
+	hook func(record []string) ([]string, error)
+	sink func(record []string) error
+}
+
+// writeEmptyFile writes the EmptyInputFile file to w, nothing or under
+// WithGzip an empty gzip stream for the Content-Encoding to hold
+func writeEmptyFile(w io.Writer, cfg *config) error {
+	if !cfg.gzip {
+		return nil
+	}
+	gz, err := gzip.NewWriterLevel(w, cfg.gzipLevel)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip writer: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to close gzip: %w", err)
+	}
+	return nil
+}
+
+// newRecordWriter returns a recordWriter for w configured by cfg
+func newRecordWriter(w io.Writer, cfg *config) (*recordWriter, error) {
+	dst := w
+	var gz *gzip.Writer
+	if cfg.gzip {
+		var err error
+		gz, err = gzip.NewWriterLevel(w, cfg.gzipLevel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip writer: %w", err)
+		}
+		w = gz
+	}
+	body := w
+	var checksum hash.Hash
+	if cfg.checksumTrailer {
+		checksum = sha256.New()
+		w = io.MultiWriter(w, checksum)
+	}
+	r, err := newQuotingWriter(w, cfg)
+	if err != nil {
+		return nil, err
+	}
+	r.gzip = gz
+	r.dst = dst
+	r.body = body
+	r.checksum = checksum
+	r.hook = cfg.recordHook
+	r.sink = cfg.sink
+	return r, nil
+}
+
+// newQuotingWriter returns a recordWriter for w with the *csv.Writer or
+// quoteWriter cfg needs, the quoteWriter takes the Comma and UseCRLF of the
+// *csv.Writer WithCSVWriter configures
+func newQuotingWriter(w io.Writer, cfg *config) (*recordWriter, error) {
+	csvWriter := csv.NewWriter(w)
+	if cfg.csvWriter != nil {
+		cfg.csvWriter(csvWriter)
+	}
+	if cfg.quote == 0 && !cfg.quoteEmptyStrings && cfg.quotePredicate == nil {
+		return &recordWriter{w: w, csv: csvWriter}, nil
+	}
+	quote := cfg.quote
+	if quote == 0 {
+		quote = '"'
+	}
+	if quote == csvWriter.Comma || quote == '\r' || quote == '\n' ||
+		quote == utf8.RuneError || !utf8.ValidRune(quote) {
+		return nil, errors.New("invalid quote character")
+	}
+	comma := csvWriter.Comma
+	if comma == 0 || comma == '\r' || comma == '\n' ||
+		comma == utf8.RuneError || !utf8.ValidRune(comma) {
+		return nil, errors.New("invalid delimiter")
+	}
+	return &recordWriter{w: w, quote: newQuoteWriter(w, csvWriter, quote)}, nil
+}
+
+// newQuoteWriter returns a quoteWriter for w with the Comma and UseCRLF of
+// csvWriter
+func newQuoteWriter(
+	w io.Writer,
+	csvWriter *csv.Writer,
+	quote rune,
+) *quoteWriter {
+	return &quoteWriter{
+		w:       bufio.NewWriter(w),
+		comma:   csvWriter.Comma,
+		quote:   quote,
+		useCRLF: csvWriter.UseCRLF,
+	}
+}
+
+// Write writes a single record
+func (r *recordWriter) Write(fields []string) error {
+	fields, skip, err := r.intercept(fields)
+	if err != nil || skip {
+		return err
+	}
+	if r.sink != nil {
+		return r.sink(fields)
+	}
+	if r.quote != nil {
+		return r.quote.Write(fields)
+	}
+	return r.csv.Write(fields)
+}
+
+// writeRow writes row keeping its quoted fields quoted, which needs the
+// quoteWriter, a *csv.Writer is swapped for one on the first quoted field,
+// or passes it to the EachRow sink
+func (r *recordWriter) writeRow(row record) error {
+	fields, skip, err := r.intercept(row.fields)
+	if err != nil || skip {
+		return err
+	}
+	row.fields = fields
+	if r.quote == nil && r.sink == nil && slices.Contains(row.quoted, true) {
+		r.csv.Flush()
+		if err := r.csv.Error(); err != nil {
+			return err
+		}
+		r.quote = newQuoteWriter(r.w, r.csv, '"')
+	}
+	switch {
+	case r.sink != nil:
+		err = r.sink(row.fields)
+	case r.quote != nil:
+		err = r.quote.writeQuoted(row.fields, row.quoted)
+	default:
+		err = r.csv.Write(row.fields)
+	}
+	if err == nil {
+		r.rows++
+	}
+	return err
+}
+
+// intercept passes a record through the WithRecordHook hook reporting
+// whether the hook skipped it, the blank line between sections is not a
+// record
+func (r *recordWriter) intercept(fields []string) ([]string, bool, error) {
+	if r.hook == nil || len(fields) == 0 {
+		return fields, false, nil
+	}
+	fields, err := r.hook(fields)
+	if err != nil {
+		return nil, false, fmt.Errorf("record hook: %w", err)
+	}
+	return fields, fields == nil, nil
+}
+
+// writeRaw writes s to the underlying io.Writer as is, after the records
+// written so far
+func (r *recordWriter) writeRaw(s string) error {
+	r.Flush()
+	if err := r.Error(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(r.w, s)
+	return err
+}
+
+// Flush writes the buffered records to the underlying io.Writer
+func (r *recordWriter) Flush() {
+	if r.quote != nil {
+		r.quote.Flush()
+		return
+	}
+	r.csv.Flush()
+}
+
+// Error reports any error from a previous Write or Flush
+func (r *recordWriter) Error() error {
+	if r.quote != nil {
+		return r.quote.Error()
+	}
+	return r.csv.Error()
+}
+
+// finish flushes the records once everything is written, through to the
+// underlying io.Writer unless WithAutoFlush is off, after writing the
+// WithChecksumTrailer trailer and the WithEOFMarker line, and closes the
+// gzip stream of WithGzip
+func (r *recordWriter) finish(cfg *config) error {
+	r.Flush()
+	if err := r.Error(); err != nil {
+		return fmt.Errorf("failed to flush: %w", err)
+	}
+	if r.checksum != nil {
+		trailer := fmt.Sprintf(
+			"#rows=%d,sha256=%x\n",
+			r.rows,
+			r.checksum.Sum(nil),
+		)
+		if _, err := io.WriteString(r.body, trailer); err != nil {
+			return fmt.Errorf("failed to write checksum trailer: %w", err)
+		}
+	}
+	if cfg.eofMarker != "" {
+		if _, err := io.WriteString(r.body, cfg.eofMarker+"\n"); err != nil {
+			return fmt.Errorf("failed to write eof marker: %w", err)
+		}
+	}
+	if r.gzip != nil {
+		if err := r.gzip.Close(); err != nil {
+			return fmt.Errorf("failed to close gzip: %w", err)
+		}
+	}
+	if cfg.autoFlush {
+		if err := flush(r.dst); err != nil {
+			return fmt.Errorf("failed to flush: %w", err)
+		}
+	}
+	return nil
+}
+
+// flushAll is Flush followed by flushing the underlying io.Writer when it
+// can be flushed, such as an http.ResponseWriter, so records reach the
+// client, under WithGzip the gzip stream is flushed first
+func (r *recordWriter) flushAll() error {
+	r.Flush()
+	if err := r.Error(); err != nil {
+		return err
+	}
+	if r.gzip != nil {
+		if err := r.gzip.Flush(); err != nil {
+			return err
+		}
+	}
+	return flush(r.dst)
+}
+
+// flush flushes w when it can be flushed
+func flush(w io.Writer) error {
+	switch f := w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush()
+	case interface{ Flush() }:
+		f.Flush()
+	}
+	return nil
+}
+
+// quoteWriter writes RFC 4180 records like *csv.Writer with a configurable
+// quote character, a quote inside a quoted field is escaped by doubling it
+type quoteWriter struct {
+	w       *bufio.Writer
+	comma   rune
+	quote   rune
+	useCRLF bool
+	err     error
+}
+
+// Write writes a single record followed by a newline
+func (q *quoteWriter) Write(record []string) error {
+	return q.writeQuoted(record, nil)
+}
+
+// writeQuoted writes a record quoting the fields marked in quoted as well as
+// those that need quotes
+func (q *quoteWriter) writeQuoted(record []string, quoted []bool) error {
+	if q.err != nil {
+		return q.err
+	}
+	for i, field := range record {
+		if i > 0 {
+			q.w.WriteRune(q.comma)
+		}
+		forced := i < len(quoted) && quoted[i]
+		if !forced && !q.needsQuotes(field) {
+			q.w.WriteString(field)
+			continue
+		}
+		q.w.WriteRune(q.quote)
+		for _, r := range field {
+			switch {
+			case r == q.quote:
+				q.w.WriteRune(q.quote)
+				q.w.WriteRune(r)
+			case r == '\r' && q.useCRLF:
+				// written with the \n that follows it
+			case r == '\n' && q.useCRLF:
+				q.w.WriteString("\r\n")
+			default:
+				q.w.WriteRune(r)
+			}
+		}
+		q.w.WriteRune(q.quote)
+	}
+	if q.useCRLF {
+		_, q.err = q.w.WriteString("\r\n")
+	} else {
+		_, q.err = q.w.WriteString("\n")
+	}
+	return q.err
+}
+
+// Flush writes any buffered data to the underlying io.Writer
+func (q *quoteWriter) Flush() {
+	if err := q.w.Flush(); err != nil && q.err == nil {
+		q.err = err
+	}
+}
+
+// Error reports any error from a previous Write or Flush
+func (q *quoteWriter) Error() error {
+	return q.err
+}
+
+// needsQuotes mirrors the rules of *csv.Writer for the configured quote
+func (q *quoteWriter) needsQuotes(field string) bool {
+	if field == "" {
+		return false
+	}
+	if field == `\.` ||
+		strings.ContainsRune(field, q.comma) ||
+		strings.ContainsRune(field, q.quote) ||
+		strings.ContainsAny(field, "\r\n") {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(r)
+}
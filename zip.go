@@ -0,0 +1,29 @@
+package struct2csv
+
+import (
+	"archive/zip"
+	"fmt"
+	"time"
+)
+
+// WriteToZip writes data as csv into a new entry name of zw, the entry is
+// modified at the WithLastModified time or now
+//
+//	zw := zip.NewWriter(f)
+//	err := struct2csv.WriteToZip(zw, "users.csv", users)
+func WriteToZip(zw *zip.Writer, name string, data any, opts ...Option) error {
+	cfg := newConfig(opts)
+	modified := cfg.lastModified
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     cfg.filename(name, data),
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create zip entry: %w", err)
+	}
+	return write(entry, data, cfg)
+}